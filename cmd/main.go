@@ -1,8 +1,12 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"log"
 	"net/http"
-	"os"
+	"time"
+	"web/clase1/internal/auth"
 	"web/clase1/internal/handlers"
 	"web/clase1/internal/repository"
 	"web/clase1/internal/service"
@@ -12,23 +16,73 @@ import (
 )
 
 func main() {
-	os.Setenv("TOKEN", "123456")
+	tokens, err := auth.LoadTokens("../docs/config/tokens.json", "API_TOKENS")
+	if err != nil {
+		panic(err)
+	}
+	apiKeys, err := auth.NewAPIKeyStore(storage.NewStorageJSON("../docs/db/api_keys.json"))
+	if err != nil {
+		panic(err)
+	}
+	authenticators := []auth.Authenticator{apiKeys, tokens}
+	bearer, err := auth.LoadJWTAuthenticator("../docs/config/jwt.json")
+	switch {
+	case errors.Is(err, auth.ErrNoSigningKeys):
+		// without keys no bearer token can be valid, leave them out
+		log.Printf("bearer tokens disabled: %s", err)
+	case err != nil:
+		panic(err)
+	default:
+		authenticators = append(authenticators, bearer)
+	}
+	authenticator := auth.Chain(authenticators...)
+	policy, err := auth.LoadPolicy("../docs/config/policy.json")
+	if err != nil {
+		panic(err)
+	}
 
-	st := storage.NewStorageJSON("../docs/db/products.json")
-	rp := repository.NewProductRepository(st)
+	cfg, err := repository.LoadConfig("../docs/config/repository.json")
+	if err != nil {
+		panic(err)
+	}
+	rp, err := repository.New(cfg)
+	if err != nil {
+		panic(err)
+	}
+	// an empty catalog makes GetAllProducts fail, it validates as such
+	products, _ := rp.GetAllProducts()
+	log.Printf("%s backend: %s", cfg.Backend, repository.Validate(products))
+	if rl, ok := rp.(repository.Reloader); ok && cfg.WatchSeconds > 0 {
+		if err := repository.Watch(context.Background(), rl, cfg.Path, time.Duration(cfg.WatchSeconds)*time.Second); err != nil {
+			panic(err)
+		}
+	}
 	sv := service.NewProductService(rp)
 	h := handlers.NewProductHandler(sv)
+	kh := handlers.NewAPIKeyHandler(apiKeys, policy)
 
 	router := chi.NewRouter()
 
 	router.Get("/products", h.GetAllProducts())
-	router.Get("/products/{id}", h.GetProductById())
-	router.Get("/products/search", h.GetProductsByPriceGt())
-	router.Post("/products", h.CreateProduct())
-	router.Put("/products/{id}", h.UpdateOrCreateProduct())
-	router.Patch("/products/{id}", h.UpdatePartial())
-	router.Delete("/products/{id}", h.DeleteProduct())
-	router.Get("/products/consumer_price", h.GetConsumerPrice())
+	router.Get("/products/search", h.SearchProducts())
+
+	router.Group(func(r chi.Router) {
+		r.Use(auth.Middleware(authenticator))
+		r.Use(auth.Authorize(policy))
+
+		r.Get("/products/{id}", h.GetProductById())
+		r.Get("/products/code/{code_value}", h.GetProductByCode())
+		r.Post("/products", h.CreateProduct())
+		r.Put("/products/{id}", h.UpdateOrCreateProduct())
+		r.Patch("/products/{id}", h.UpdatePartial())
+		r.Delete("/products/{id}", h.DeleteProduct())
+		r.Get("/products/consumer_price", h.GetConsumerPrice())
+
+		r.Get("/admin/api-keys", kh.ListAPIKeys())
+		r.Post("/admin/api-keys", kh.CreateAPIKey())
+		r.Delete("/admin/api-keys/{id}", kh.RevokeAPIKey())
+		r.Post("/admin/api-keys/{id}/rotate", kh.RotateAPIKey())
+	})
 
 	if err := http.ListenAndServe(":8080", router); err != nil {
 		panic(err)
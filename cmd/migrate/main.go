@@ -0,0 +1,46 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"web/clase1/internal/repository"
+)
+
+// migrate upgrades the products file of the configured backend to the
+// current schema version, or with -dry-run reports what it would do. With
+// -encrypt it encrypts a plaintext json backend file with the configured
+// keys instead.
+func main() {
+	config := flag.String("config", "../../docs/config/repository.json", "repository config file")
+	dryRun := flag.Bool("dry-run", false, "report the migrations without writing the file")
+	encrypt := flag.Bool("encrypt", false, "encrypt a plaintext products file with the configured keys")
+	flag.Parse()
+
+	cfg, err := repository.LoadConfig(*config)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *encrypt {
+		plaintext, err := repository.EncryptFile(cfg, *dryRun)
+		switch {
+		case err != nil:
+			log.Fatal(err)
+		case !plaintext:
+			log.Printf("%s is already encrypted", cfg.Path)
+		case *dryRun:
+			log.Printf("would encrypt %s", cfg.Path)
+		case cfg.Backups > 0:
+			log.Printf("encrypted %s, delete its backups, they hold the plaintext", cfg.Path)
+		default:
+			log.Printf("encrypted %s", cfg.Path)
+		}
+		return
+	}
+
+	report, err := repository.MigrateFile(cfg, *dryRun)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Print(report)
+}
@@ -0,0 +1,315 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+	"web/clase1/internal/storage"
+)
+
+var (
+	ErrAPIKeyNotFound = errors.New("api key not found")
+	ErrAPIKeyRevoked  = errors.New("api key is revoked")
+)
+
+const (
+	apiKeyPrefix = "ak_"
+	// lastUsedResolution bounds how often a key's last use is persisted
+	lastUsedResolution = time.Minute
+)
+
+// APIKey is the public view of an issued key. The key itself is only
+// returned once, when it's created or rotated.
+type APIKey struct {
+	Id         int        `json:"id"`
+	Name       string     `json:"name"`
+	Role       string     `json:"role"`
+	Prefix     string     `json:"prefix"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+}
+
+// storedAPIKey is an APIKey as persisted, with the SHA-256 of the key
+type storedAPIKey struct {
+	APIKey
+	Hash string `json:"hash"`
+}
+
+// APIKeyStore issues API keys, persists their hashes through a Storage
+// and authenticates requests carrying them. Last uses are persisted in the
+// background, so authenticating never waits on the disk.
+type APIKeyStore struct {
+	mu   sync.Mutex
+	keys []storedAPIKey
+	// dirty is set when a last use isn't persisted yet
+	dirty bool
+	// flushing is set while last uses are persisted in the background,
+	// flushes waits for them
+	flushing bool
+	flushes  sync.WaitGroup
+	// writeMu serializes writes, so an older snapshot of keys never
+	// overwrites a newer one. It's locked before mu.
+	writeMu sync.Mutex
+	storage storage.Storage
+	now     func() time.Time
+}
+
+func NewAPIKeyStore(st storage.Storage) (*APIKeyStore, error) {
+	data, err := st.Read()
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []storedAPIKey
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return nil, err
+	}
+
+	return &APIKeyStore{
+		keys:    keys,
+		storage: st,
+		now:     time.Now,
+	}, nil
+}
+
+// List returns every issued key, revoked ones included
+func (s *APIKeyStore) List() []APIKey {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keys := make([]APIKey, 0, len(s.keys))
+	for _, k := range s.keys {
+		keys = append(keys, k.APIKey)
+	}
+	return keys
+}
+
+// Create issues a new key and returns it along with its stored metadata
+func (s *APIKeyStore) Create(name, role string) (string, *APIKey, error) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	plain, err := generateAPIKey()
+	if err != nil {
+		return "", nil, err
+	}
+
+	id := 1
+	for _, k := range s.keys {
+		if k.Id >= id {
+			id = k.Id + 1
+		}
+	}
+
+	key := storedAPIKey{
+		APIKey: APIKey{
+			Id:        id,
+			Name:      name,
+			Role:      role,
+			Prefix:    plain[:len(apiKeyPrefix)+6],
+			CreatedAt: s.now().UTC(),
+		},
+		Hash: hashAPIKey(plain),
+	}
+	s.keys = append(s.keys, key)
+
+	if err := s.save(); err != nil {
+		s.keys = s.keys[:len(s.keys)-1]
+		return "", nil, err
+	}
+	return plain, &key.APIKey, nil
+}
+
+// Revoke disables a key. Revoked keys stay listed but no longer authenticate.
+func (s *APIKeyStore) Revoke(id int) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i, err := s.active(id)
+	if err != nil {
+		return err
+	}
+
+	now := s.now().UTC()
+	s.keys[i].RevokedAt = &now
+	if err := s.save(); err != nil {
+		s.keys[i].RevokedAt = nil
+		return err
+	}
+	return nil
+}
+
+// Rotate replaces the secret of a key, invalidating the previous one
+func (s *APIKeyStore) Rotate(id int) (string, *APIKey, error) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i, err := s.active(id)
+	if err != nil {
+		return "", nil, err
+	}
+
+	plain, err := generateAPIKey()
+	if err != nil {
+		return "", nil, err
+	}
+
+	previous := s.keys[i]
+	s.keys[i].Hash = hashAPIKey(plain)
+	s.keys[i].Prefix = plain[:len(apiKeyPrefix)+6]
+	s.keys[i].LastUsedAt = nil
+	if err := s.save(); err != nil {
+		s.keys[i] = previous
+		return "", nil, err
+	}
+
+	key := s.keys[i].APIKey
+	return plain, &key, nil
+}
+
+// Authenticate resolves the identity of a request carrying an API key
+func (s *APIKeyStore) Authenticate(r *http.Request) (Identity, error) {
+	credential := Credential(r)
+	if credential == "" {
+		return Identity{}, ErrMissingCredentials
+	}
+	if !strings.HasPrefix(credential, apiKeyPrefix) {
+		return Identity{}, ErrInvalidCredentials
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	hash := []byte(hashAPIKey(credential))
+	for i, k := range s.keys {
+		if subtle.ConstantTimeCompare([]byte(k.Hash), hash) != 1 || k.RevokedAt != nil {
+			continue
+		}
+
+		now := s.now().UTC()
+		if k.LastUsedAt == nil || now.Sub(*k.LastUsedAt) >= lastUsedResolution {
+			s.keys[i].LastUsedAt = &now
+			s.dirty = true
+			if !s.flushing {
+				s.flushing = true
+				s.flushes.Add(1)
+				// failing to record the last use must not reject a valid key
+				go s.flushInBackground()
+			}
+		}
+		return Identity{Name: k.Name, Roles: []string{k.Role}}, nil
+	}
+	return Identity{}, ErrInvalidCredentials
+}
+
+// Flush persists the last uses recorded since the previous write. When
+// the write fails they are kept for the next one.
+func (s *APIKeyStore) Flush() error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	s.mu.Lock()
+	if !s.dirty {
+		s.mu.Unlock()
+		return nil
+	}
+	data, err := json.Marshal(s.keys)
+	if err != nil {
+		s.mu.Unlock()
+		return err
+	}
+	s.dirty = false
+	s.mu.Unlock()
+
+	if err := s.storage.Write(data); err != nil {
+		s.mu.Lock()
+		s.dirty = true
+		s.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// flushInBackground flushes until no last use is left to persist. A failed
+// write is logged, and retried once another last use is recorded.
+func (s *APIKeyStore) flushInBackground() {
+	defer s.flushes.Done()
+
+	for {
+		err := s.Flush()
+		if err != nil {
+			log.Printf("could not record the last use of API keys: %v", err)
+		}
+
+		s.mu.Lock()
+		if err != nil || !s.dirty {
+			s.flushing = false
+			s.mu.Unlock()
+			return
+		}
+		s.mu.Unlock()
+	}
+}
+
+// Close waits for the last uses being persisted in the background, then
+// persists the ones left
+func (s *APIKeyStore) Close() error {
+	s.flushes.Wait()
+	return s.Flush()
+}
+
+// active returns the index of the non revoked key with the given id
+func (s *APIKeyStore) active(id int) (int, error) {
+	for i, k := range s.keys {
+		if k.Id == id {
+			if k.RevokedAt != nil {
+				return 0, ErrAPIKeyRevoked
+			}
+			return i, nil
+		}
+	}
+	return 0, ErrAPIKeyNotFound
+}
+
+// save writes every key, pending last uses included. Callers hold both
+// writeMu and mu.
+func (s *APIKeyStore) save() error {
+	data, err := json.Marshal(s.keys)
+	if err != nil {
+		return err
+	}
+	if err := s.storage.Write(data); err != nil {
+		return err
+	}
+	s.dirty = false
+	return nil
+}
+
+func generateAPIKey() (string, error) {
+	secret := make([]byte, 24)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return apiKeyPrefix + base64.RawURLEncoding.EncodeToString(secret), nil
+}
+
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
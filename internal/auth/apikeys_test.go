@@ -0,0 +1,135 @@
+package auth
+
+import (
+	"errors"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+	"web/clase1/internal/storage"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newTestAPIKeyStore(t *testing.T) (*APIKeyStore, string) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "api_keys.json")
+	require.NoError(t, os.WriteFile(path, []byte("[]"), 0644))
+	s, err := NewAPIKeyStore(storage.NewStorageJSON(path))
+	require.NoError(t, err)
+	// last uses are written in the background, before the dir is removed
+	t.Cleanup(func() { require.NoError(t, s.Close()) })
+	return s, path
+}
+
+var errWriteFailed = errors.New("write failed")
+
+// failingStorage fails its writes while fail is set
+type failingStorage struct {
+	storage.Storage
+	fail atomic.Bool
+}
+
+func (s *failingStorage) Write(data []byte) error {
+	if s.fail.Load() {
+		return errWriteFailed
+	}
+	return s.Storage.Write(data)
+}
+
+func authenticateKey(s *APIKeyStore, key string) (Identity, error) {
+	req := httptest.NewRequest("GET", "/products/1", nil)
+	req.Header.Set("Authorization", key)
+	return s.Authenticate(req)
+}
+
+func TestAPIKeyStore(t *testing.T) {
+	t.Run("should authenticate a created key and persist only its hash", func(t *testing.T) {
+		// Arrange
+		s, path := newTestAPIKeyStore(t)
+		// Act
+		key, k, err := s.Create("merchandising", "editor")
+		require.NoError(t, err)
+		id, err := authenticateKey(s, key)
+		// Assert
+		require.NoError(t, err)
+		require.Equal(t, Identity{Name: "merchandising", Roles: []string{"editor"}}, id)
+		require.True(t, strings.HasPrefix(key, k.Prefix))
+
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		require.NotContains(t, string(data), key)
+		require.Contains(t, string(data), hashAPIKey(key))
+	})
+	t.Run("should record the last use of a key", func(t *testing.T) {
+		// Arrange
+		s, path := newTestAPIKeyStore(t)
+		now := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+		s.now = func() time.Time { return now }
+		key, _, err := s.Create("merchandising", "editor")
+		require.NoError(t, err)
+		// Act
+		_, err = authenticateKey(s, key)
+		require.NoError(t, err)
+		require.NoError(t, s.Flush())
+		// Assert
+		reloaded, err := NewAPIKeyStore(storage.NewStorageJSON(path))
+		require.NoError(t, err)
+		require.Equal(t, now, *reloaded.List()[0].LastUsedAt)
+	})
+	t.Run("should keep a last use whose write failed for the next flush", func(t *testing.T) {
+		// Arrange
+		st := &failingStorage{Storage: storage.NewStorageMemory([]byte("[]"))}
+		s, err := NewAPIKeyStore(st)
+		require.NoError(t, err)
+		now := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+		s.now = func() time.Time { return now }
+		key, _, err := s.Create("merchandising", "editor")
+		require.NoError(t, err)
+		st.fail.Store(true)
+		// Act
+		_, err = authenticateKey(s, key)
+		require.NoError(t, err)
+		s.flushes.Wait()
+		require.ErrorIs(t, s.Flush(), errWriteFailed)
+		st.fail.Store(false)
+		require.NoError(t, s.Close())
+		// Assert
+		reloaded, err := NewAPIKeyStore(st)
+		require.NoError(t, err)
+		require.Equal(t, now, *reloaded.List()[0].LastUsedAt)
+	})
+	t.Run("should stop accepting the previous key after a rotation", func(t *testing.T) {
+		// Arrange
+		s, _ := newTestAPIKeyStore(t)
+		old, k, err := s.Create("merchandising", "editor")
+		require.NoError(t, err)
+		// Act
+		key, _, err := s.Rotate(k.Id)
+		require.NoError(t, err)
+		// Assert
+		_, err = authenticateKey(s, old)
+		require.ErrorIs(t, err, ErrInvalidCredentials)
+		_, err = authenticateKey(s, key)
+		require.NoError(t, err)
+	})
+	t.Run("should stop accepting a revoked key", func(t *testing.T) {
+		// Arrange
+		s, _ := newTestAPIKeyStore(t)
+		key, k, err := s.Create("merchandising", "editor")
+		require.NoError(t, err)
+		// Act
+		require.NoError(t, s.Revoke(k.Id))
+		// Assert
+		_, err = authenticateKey(s, key)
+		require.ErrorIs(t, err, ErrInvalidCredentials)
+		require.ErrorIs(t, s.Revoke(k.Id), ErrAPIKeyRevoked)
+		_, _, err = s.Rotate(k.Id)
+		require.ErrorIs(t, err, ErrAPIKeyRevoked)
+		require.ErrorIs(t, s.Revoke(42), ErrAPIKeyNotFound)
+	})
+}
@@ -0,0 +1,80 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+var (
+	ErrMissingCredentials = errors.New("missing credentials")
+	ErrInvalidCredentials = errors.New("invalid credentials")
+)
+
+// Identity is the caller resolved from the request credentials.
+// Claims is only set when the caller authenticated with a JWT.
+type Identity struct {
+	Name   string   `json:"name"`
+	Roles  []string `json:"roles"`
+	Scopes []string `json:"scopes"`
+	Claims *Claims  `json:"-"`
+}
+
+// HasScope reports whether the identity was granted the given scope
+func (i Identity) HasScope(scope string) bool {
+	for _, s := range i.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Authenticator resolves the identity behind the credentials of a request
+type Authenticator interface {
+	Authenticate(r *http.Request) (Identity, error)
+}
+
+type identityKey struct{}
+
+// WithIdentity returns a copy of ctx carrying the given identity
+func WithIdentity(ctx context.Context, id Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, id)
+}
+
+// IdentityFromContext returns the identity stored in ctx, if any
+func IdentityFromContext(ctx context.Context) (Identity, bool) {
+	id, ok := ctx.Value(identityKey{}).(Identity)
+	return id, ok
+}
+
+// ClaimsFromContext returns the JWT claims of the caller stored in ctx, if any
+func ClaimsFromContext(ctx context.Context) (*Claims, bool) {
+	id, ok := IdentityFromContext(ctx)
+	if !ok || id.Claims == nil {
+		return nil, false
+	}
+	return id.Claims, true
+}
+
+// Chain tries each authenticator in order and returns the first identity
+// resolved. It fails with ErrMissingCredentials only if every authenticator did.
+func Chain(authenticators ...Authenticator) Authenticator {
+	return chain(authenticators)
+}
+
+type chain []Authenticator
+
+func (c chain) Authenticate(r *http.Request) (Identity, error) {
+	err := ErrMissingCredentials
+	for _, a := range c {
+		id, e := a.Authenticate(r)
+		if e == nil {
+			return id, nil
+		}
+		if !errors.Is(e, ErrMissingCredentials) {
+			err = e
+		}
+	}
+	return Identity{}, err
+}
@@ -0,0 +1,77 @@
+package auth
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"web/clase1/platform/tools"
+)
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	K   string `json:"k"`
+}
+
+// LoadJWKS reads a JSON Web Key Set from disk. RSA keys are returned as
+// *rsa.PublicKey and symmetric ("oct") keys as []byte, indexed by kid.
+func LoadJWKS(path string) (map[string]any, error) {
+	data, err := tools.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	keys := make(map[string]any)
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		switch k.Kty {
+		case "RSA":
+			key, err := k.rsaPublicKey()
+			if err != nil {
+				return nil, fmt.Errorf("%s: key %q: %w", path, k.Kid, err)
+			}
+			keys[k.Kid] = key
+		case "oct":
+			key, err := base64.RawURLEncoding.DecodeString(k.K)
+			if err != nil {
+				return nil, fmt.Errorf("%s: key %q: %w", path, k.Kid, err)
+			}
+			keys[k.Kid] = key
+		}
+	}
+	return keys, nil
+}
+
+func (k jwk) rsaPublicKey() (*rsa.PublicKey, error) {
+	n, err := base64.RawURLEncoding.DecodeString(k.N)
+	if err != nil {
+		return nil, err
+	}
+	e, err := base64.RawURLEncoding.DecodeString(k.E)
+	if err != nil {
+		return nil, err
+	}
+
+	exponent := new(big.Int).SetBytes(e)
+	if !exponent.IsInt64() || exponent.Int64() > 1<<31-1 {
+		return nil, fmt.Errorf("invalid exponent")
+	}
+	return &rsa.PublicKey{
+		N: new(big.Int).SetBytes(n),
+		E: int(exponent.Int64()),
+	}, nil
+}
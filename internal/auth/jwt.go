@@ -0,0 +1,228 @@
+package auth
+
+import (
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"web/clase1/platform/tools"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// ErrNoSigningKeys is returned by LoadJWTAuthenticator when none of the
+// configured keys is available, bearer tokens can't be validated then
+var ErrNoSigningKeys = errors.New("no signing keys configured")
+
+// Claims are the JWT claims the API understands. Scopes are read from
+// either the space separated "scope" claim or the "scp" array.
+type Claims struct {
+	jwt.RegisteredClaims
+	Scope  string   `json:"scope,omitempty"`
+	Scopes []string `json:"scp,omitempty"`
+	Roles  []string `json:"roles,omitempty"`
+}
+
+// AllScopes returns the scopes of both the "scope" and "scp" claims
+func (c *Claims) AllScopes() []string {
+	scopes := append([]string{}, c.Scopes...)
+	return append(scopes, strings.Fields(c.Scope)...)
+}
+
+// JWTConfig describes how bearer tokens are validated. Key files are
+// resolved relative to the directory of the config file.
+type JWTConfig struct {
+	Issuer        string    `json:"issuer"`
+	Audience      string    `json:"audience"`
+	LeewaySeconds int       `json:"leeway_seconds"`
+	HMACKeys      []KeyFile `json:"hmac_keys"`
+	RSAKeys       []KeyFile `json:"rsa_keys"`
+	JWKSFile      string    `json:"jwks_file"`
+}
+
+// KeyFile points to a key identified by the "kid" token header, on disk or
+// for HMAC secrets in an environment variable, which wins when it's set
+type KeyFile struct {
+	Kid  string `json:"kid"`
+	File string `json:"file"`
+	Env  string `json:"env"`
+}
+
+// JWTAuthenticator validates HS256 and RS256 signed bearer tokens
+type JWTAuthenticator struct {
+	keys   map[string]any
+	parser *jwt.Parser
+}
+
+func NewJWTAuthenticator(keys map[string]any, issuer, audience string, leeway time.Duration) *JWTAuthenticator {
+	opts := []jwt.ParserOption{
+		jwt.WithValidMethods([]string{"HS256", "RS256"}),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(leeway),
+	}
+	if issuer != "" {
+		opts = append(opts, jwt.WithIssuer(issuer))
+	}
+	if audience != "" {
+		opts = append(opts, jwt.WithAudience(audience))
+	}
+
+	return &JWTAuthenticator{
+		keys:   keys,
+		parser: jwt.NewParser(opts...),
+	}
+}
+
+// LoadJWTAuthenticator reads a JWTConfig from path and loads all the keys it references
+func LoadJWTAuthenticator(path string) (*JWTAuthenticator, error) {
+	data, err := tools.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var cfg JWTConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	dir := filepath.Dir(path)
+	resolve := func(file string) string {
+		if filepath.IsAbs(file) {
+			return file
+		}
+		return filepath.Join(dir, file)
+	}
+
+	keys := make(map[string]any)
+	for _, k := range cfg.HMACKeys {
+		secret, err := readSecret(k, resolve)
+		if err != nil {
+			return nil, err
+		}
+		if secret == nil {
+			// neither its variable nor its file is there, the key isn't configured
+			continue
+		}
+		keys[k.Kid] = secret
+	}
+	for _, k := range cfg.RSAKeys {
+		pem, err := tools.ReadFile(resolve(k.File))
+		if err != nil {
+			return nil, err
+		}
+		key, err := jwt.ParseRSAPublicKeyFromPEM(pem)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", k.File, err)
+		}
+		keys[k.Kid] = key
+	}
+	if cfg.JWKSFile != "" {
+		jwks, err := LoadJWKS(resolve(cfg.JWKSFile))
+		if err != nil {
+			return nil, err
+		}
+		for kid, key := range jwks {
+			keys[kid] = key
+		}
+	}
+
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%s: %w", path, ErrNoSigningKeys)
+	}
+
+	return NewJWTAuthenticator(keys, cfg.Issuer, cfg.Audience, time.Duration(cfg.LeewaySeconds)*time.Second), nil
+}
+
+// readSecret returns the HMAC secret of k, from its environment variable
+// when it's set and otherwise from its file. It returns a nil secret when
+// neither is there, and fails when the one found is blank.
+func readSecret(k KeyFile, resolve func(string) string) ([]byte, error) {
+	var secret string
+	if value, ok := os.LookupEnv(k.Env); ok && k.Env != "" {
+		secret = value
+	} else if k.File != "" {
+		data, err := tools.ReadFile(resolve(k.File))
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		secret = string(data)
+	} else {
+		return nil, nil
+	}
+
+	secret = strings.TrimSpace(secret)
+	if secret == "" {
+		return nil, fmt.Errorf("hmac key %q: %w", k.Kid, errors.New("empty secret"))
+	}
+	return []byte(secret), nil
+}
+
+// Authenticate validates the bearer token of the request and returns the
+// identity of its subject
+func (a *JWTAuthenticator) Authenticate(r *http.Request) (Identity, error) {
+	credential := Credential(r)
+	if credential == "" {
+		return Identity{}, ErrMissingCredentials
+	}
+	// static tokens and api keys are not JWTs
+	if strings.Count(credential, ".") != 2 {
+		return Identity{}, ErrInvalidCredentials
+	}
+
+	var claims Claims
+	if _, err := a.parser.ParseWithClaims(credential, &claims, a.key); err != nil {
+		return Identity{}, fmt.Errorf("%w: %v", ErrInvalidCredentials, err)
+	}
+	if claims.Subject == "" {
+		return Identity{}, fmt.Errorf("%w: %v", ErrInvalidCredentials, "token has no subject")
+	}
+
+	return Identity{
+		Name:   claims.Subject,
+		Roles:  claims.Roles,
+		Scopes: claims.AllScopes(),
+		Claims: &claims,
+	}, nil
+}
+
+// key picks the verification key by the "kid" header. Tokens without a kid
+// are accepted only when a single key is configured.
+func (a *JWTAuthenticator) key(t *jwt.Token) (any, error) {
+	kid, _ := t.Header["kid"].(string)
+	if kid == "" && len(a.keys) == 1 {
+		for _, key := range a.keys {
+			return checkKeyType(t, key)
+		}
+	}
+
+	key, ok := a.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key id %q", kid)
+	}
+	return checkKeyType(t, key)
+}
+
+// checkKeyType rejects tokens whose algorithm doesn't match the key kind,
+// so an RSA public key can never be used as an HMAC secret
+func checkKeyType(t *jwt.Token, key any) (any, error) {
+	switch key.(type) {
+	case []byte:
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); ok {
+			return key, nil
+		}
+	case *rsa.PublicKey:
+		if _, ok := t.Method.(*jwt.SigningMethodRSA); ok {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("unexpected signing method %s", t.Method.Alg())
+}
@@ -0,0 +1,200 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/require"
+)
+
+func signedToken(t *testing.T, method jwt.SigningMethod, kid string, key any, claims jwt.MapClaims) string {
+	t.Helper()
+
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(key)
+	require.NoError(t, err)
+	return signed
+}
+
+func validClaims() jwt.MapClaims {
+	return jwt.MapClaims{
+		"sub":   "catalog-service",
+		"iss":   "https://auth.local",
+		"aud":   "products-api",
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"scope": "products:read products:write",
+	}
+}
+
+func TestJWTAuthenticator(t *testing.T) {
+	secret := []byte("secret")
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	a := NewJWTAuthenticator(map[string]any{
+		"hs": secret,
+		"rs": &rsaKey.PublicKey,
+	}, "https://auth.local", "products-api", 0)
+
+	authenticate := func(token string) (Identity, error) {
+		req := httptest.NewRequest("GET", "/products/1", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		return a.Authenticate(req)
+	}
+
+	t.Run("should accept an HS256 token", func(t *testing.T) {
+		id, err := authenticate(signedToken(t, jwt.SigningMethodHS256, "hs", secret, validClaims()))
+
+		require.NoError(t, err)
+		require.Equal(t, "catalog-service", id.Name)
+		require.Equal(t, []string{"products:read", "products:write"}, id.Scopes)
+		require.Equal(t, "catalog-service", id.Claims.Subject)
+	})
+	t.Run("should accept an RS256 token", func(t *testing.T) {
+		claims := validClaims()
+		delete(claims, "scope")
+		claims["scp"] = []string{"products:delete"}
+
+		id, err := authenticate(signedToken(t, jwt.SigningMethodRS256, "rs", rsaKey, claims))
+
+		require.NoError(t, err)
+		require.True(t, id.HasScope("products:delete"))
+	})
+	t.Run("should reject an expired token", func(t *testing.T) {
+		claims := validClaims()
+		claims["exp"] = time.Now().Add(-time.Minute).Unix()
+
+		_, err := authenticate(signedToken(t, jwt.SigningMethodHS256, "hs", secret, claims))
+
+		require.ErrorIs(t, err, ErrInvalidCredentials)
+	})
+	t.Run("should reject a token that is not valid yet", func(t *testing.T) {
+		claims := validClaims()
+		claims["nbf"] = time.Now().Add(time.Hour).Unix()
+
+		_, err := authenticate(signedToken(t, jwt.SigningMethodHS256, "hs", secret, claims))
+
+		require.ErrorIs(t, err, ErrInvalidCredentials)
+	})
+	t.Run("should reject a token from another issuer or audience", func(t *testing.T) {
+		claims := validClaims()
+		claims["iss"] = "https://evil.local"
+		_, err := authenticate(signedToken(t, jwt.SigningMethodHS256, "hs", secret, claims))
+		require.ErrorIs(t, err, ErrInvalidCredentials)
+
+		claims = validClaims()
+		claims["aud"] = "other-api"
+		_, err = authenticate(signedToken(t, jwt.SigningMethodHS256, "hs", secret, claims))
+		require.ErrorIs(t, err, ErrInvalidCredentials)
+	})
+	t.Run("should reject a token signed with the wrong key kind", func(t *testing.T) {
+		_, err := authenticate(signedToken(t, jwt.SigningMethodHS256, "rs", secret, validClaims()))
+
+		require.ErrorIs(t, err, ErrInvalidCredentials)
+	})
+}
+
+func TestLoadJWTAuthenticator(t *testing.T) {
+	t.Run("should load hmac keys and a jwks file relative to the config", func(t *testing.T) {
+		// Arrange
+		rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+		require.NoError(t, err)
+
+		dir := t.TempDir()
+		jwks := fmt.Sprintf(`{"keys":[{"kty":"RSA","kid":"rs","use":"sig","n":%q,"e":%q}]}`,
+			base64.RawURLEncoding.EncodeToString(rsaKey.N.Bytes()),
+			base64.RawURLEncoding.EncodeToString(big.NewInt(int64(rsaKey.E)).Bytes()),
+		)
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "jwks.json"), []byte(jwks), 0644))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "hs.key"), []byte("secret\n"), 0600))
+		config := `{"issuer":"https://auth.local","audience":"products-api","hmac_keys":[{"kid":"hs","file":"hs.key"}],"jwks_file":"jwks.json"}`
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "jwt.json"), []byte(config), 0644))
+
+		// Act
+		a, err := LoadJWTAuthenticator(filepath.Join(dir, "jwt.json"))
+		require.NoError(t, err)
+
+		// Assert
+		for _, token := range []string{
+			signedToken(t, jwt.SigningMethodHS256, "hs", []byte("secret"), validClaims()),
+			signedToken(t, jwt.SigningMethodRS256, "rs", rsaKey, validClaims()),
+		} {
+			req := httptest.NewRequest("GET", "/products/1", nil)
+			req.Header.Set("Authorization", "Bearer "+token)
+			_, err := a.Authenticate(req)
+			require.NoError(t, err)
+		}
+	})
+	t.Run("should prefer the environment variable of an hmac key to its file", func(t *testing.T) {
+		// Arrange
+		dir := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "hs.key"), []byte("file-secret\n"), 0600))
+		config := `{"hmac_keys":[{"kid":"hs","env":"TEST_JWT_HS_SECRET","file":"hs.key"}]}`
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "jwt.json"), []byte(config), 0644))
+		t.Setenv("TEST_JWT_HS_SECRET", "env-secret")
+
+		// Act
+		a, err := LoadJWTAuthenticator(filepath.Join(dir, "jwt.json"))
+		require.NoError(t, err)
+
+		// Assert
+		req := httptest.NewRequest("GET", "/products/1", nil)
+		req.Header.Set("Authorization", "Bearer "+signedToken(t, jwt.SigningMethodHS256, "hs", []byte("env-secret"), validClaims()))
+		_, err = a.Authenticate(req)
+		require.NoError(t, err)
+	})
+
+	t.Run("should skip an hmac key that has no secret", func(t *testing.T) {
+		// Arrange
+		dir := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "other.key"), []byte("other-secret\n"), 0600))
+		config := `{"hmac_keys":[{"kid":"hs","env":"TEST_JWT_HS_MISSING","file":"hs.key"},{"kid":"other","file":"other.key"}]}`
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "jwt.json"), []byte(config), 0644))
+
+		// Act
+		a, err := LoadJWTAuthenticator(filepath.Join(dir, "jwt.json"))
+		require.NoError(t, err)
+
+		// Assert
+		req := httptest.NewRequest("GET", "/products/1", nil)
+		req.Header.Set("Authorization", "Bearer "+signedToken(t, jwt.SigningMethodHS256, "hs", []byte("other-secret"), validClaims()))
+		_, err = a.Authenticate(req)
+		require.ErrorIs(t, err, ErrInvalidCredentials)
+	})
+	t.Run("should fail with ErrNoSigningKeys when no key is configured", func(t *testing.T) {
+		// Arrange
+		dir := t.TempDir()
+		config := `{"hmac_keys":[{"kid":"hs","env":"TEST_JWT_HS_MISSING","file":"hs.key"}]}`
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "jwt.json"), []byte(config), 0644))
+
+		// Act
+		_, err := LoadJWTAuthenticator(filepath.Join(dir, "jwt.json"))
+
+		// Assert
+		require.ErrorIs(t, err, ErrNoSigningKeys)
+	})
+	t.Run("should fail when the file of an hmac key is blank", func(t *testing.T) {
+		// Arrange
+		dir := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "hs.key"), []byte("\n"), 0600))
+		config := `{"hmac_keys":[{"kid":"hs","file":"hs.key"}]}`
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "jwt.json"), []byte(config), 0644))
+
+		// Act
+		_, err := LoadJWTAuthenticator(filepath.Join(dir, "jwt.json"))
+
+		// Assert
+		require.Error(t, err)
+		require.NotErrorIs(t, err, ErrNoSigningKeys)
+	})
+}
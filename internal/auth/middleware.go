@@ -0,0 +1,28 @@
+package auth
+
+import (
+	"net/http"
+	"web/clase1/internal/web"
+
+	"github.com/bootcamp-go/web/response"
+)
+
+// Middleware authenticates every request with a and stores the resolved
+// identity in the request context. Requests that fail get a 401.
+func Middleware(a Authenticator) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id, err := a.Authenticate(r)
+			if err != nil {
+				body := web.StandarResponse{
+					StatusCode: http.StatusUnauthorized,
+					Message:    "Unauthorized",
+				}
+				response.JSON(w, http.StatusUnauthorized, body)
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(WithIdentity(r.Context(), id)))
+		})
+	}
+}
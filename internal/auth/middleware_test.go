@@ -0,0 +1,71 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMiddleware(t *testing.T) {
+	tokens := NewTokenStore(
+		Token{Name: "backoffice", Token: "123456"},
+		Token{Name: "warehouse", Token: "abcdef"},
+	)
+	// echoes the identity found in the context
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, ok := IdentityFromContext(r.Context())
+		require.True(t, ok)
+		w.Write([]byte(id.Name))
+	})
+
+	t.Run("should inject the identity of a known token", func(t *testing.T) {
+		// Arrange
+		hd := Middleware(tokens)(next)
+		// Act
+		req := httptest.NewRequest("GET", "/products/1", nil)
+		req.Header.Set("Authorization", "abcdef")
+		res := httptest.NewRecorder()
+		hd.ServeHTTP(res, req)
+		// Assert
+		require.Equal(t, 200, res.Code)
+		require.Equal(t, "warehouse", res.Body.String())
+	})
+	t.Run("should accept a bearer token", func(t *testing.T) {
+		// Arrange
+		hd := Middleware(tokens)(next)
+		// Act
+		req := httptest.NewRequest("GET", "/products/1", nil)
+		req.Header.Set("Authorization", "Bearer 123456")
+		res := httptest.NewRecorder()
+		hd.ServeHTTP(res, req)
+		// Assert
+		require.Equal(t, 200, res.Code)
+		require.Equal(t, "backoffice", res.Body.String())
+	})
+	t.Run("should return unauthorized when the token is unknown", func(t *testing.T) {
+		// Arrange
+		hd := Middleware(tokens)(next)
+		// Act
+		req := httptest.NewRequest("GET", "/products/1", nil)
+		req.Header.Set("Authorization", "nope")
+		res := httptest.NewRecorder()
+		hd.ServeHTTP(res, req)
+		// Assert
+		expectedBody := `{"status_code":401,"message":"Unauthorized","data":null}`
+
+		require.Equal(t, 401, res.Code)
+		require.Equal(t, expectedBody, res.Body.String())
+	})
+	t.Run("should return unauthorized when the header is missing", func(t *testing.T) {
+		// Arrange
+		hd := Middleware(tokens)(next)
+		// Act
+		req := httptest.NewRequest("GET", "/products/1", nil)
+		res := httptest.NewRecorder()
+		hd.ServeHTTP(res, req)
+		// Assert
+		require.Equal(t, 401, res.Code)
+	})
+}
@@ -0,0 +1,86 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"web/clase1/internal/web"
+	"web/clase1/platform/tools"
+
+	"github.com/bootcamp-go/web/response"
+	"github.com/go-chi/chi/v5"
+)
+
+// Policy grants scopes to roles and requires a scope for each route.
+// Routes are keyed by method and chi pattern, e.g. "DELETE /products/{id}".
+type Policy struct {
+	Roles  map[string][]string `json:"roles"`
+	Routes map[string]string   `json:"routes"`
+}
+
+// LoadPolicy reads a Policy from a JSON file
+func LoadPolicy(path string) (*Policy, error) {
+	data, err := tools.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var p Policy
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for route, scope := range p.Routes {
+		if len(strings.Fields(route)) != 2 || scope == "" {
+			return nil, fmt.Errorf("%s: invalid route rule %q: %q", path, route, scope)
+		}
+	}
+	return &p, nil
+}
+
+// Scopes returns the scopes granted to id, directly or through its roles
+func (p *Policy) Scopes(id Identity) []string {
+	scopes := append([]string{}, id.Scopes...)
+	for _, role := range id.Roles {
+		scopes = append(scopes, p.Roles[role]...)
+	}
+	return scopes
+}
+
+// Allows reports whether id holds the scope required by the route.
+// Routes missing from the policy are denied.
+func (p *Policy) Allows(id Identity, method, pattern string) bool {
+	required, ok := p.Routes[method+" "+pattern]
+	if !ok {
+		return false
+	}
+	for _, scope := range p.Scopes(id) {
+		if scope == required {
+			return true
+		}
+	}
+	return false
+}
+
+// Authorize checks the identity set by Middleware against the policy rule
+// of the matched route, answering 403 when the required scope is missing.
+// It must run inside the routed group so the route pattern is known.
+func Authorize(p *Policy) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id, _ := IdentityFromContext(r.Context())
+			pattern := chi.RouteContext(r.Context()).RoutePattern()
+
+			if !p.Allows(id, r.Method, pattern) {
+				body := web.StandarResponse{
+					StatusCode: http.StatusForbidden,
+					Message:    "Forbidden",
+				}
+				response.JSON(w, http.StatusForbidden, body)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,69 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuthorize(t *testing.T) {
+	policy, err := LoadPolicy("../../docs/config/policy.json")
+	require.NoError(t, err)
+
+	tokens := NewTokenStore(
+		Token{Name: "support", Token: "viewer-token", Role: "viewer"},
+		Token{Name: "merch", Token: "editor-token", Role: "editor"},
+		Token{Name: "backoffice", Token: "admin-token", Role: "admin"},
+	)
+	ok := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) }
+
+	router := chi.NewRouter()
+	router.Group(func(r chi.Router) {
+		r.Use(Middleware(tokens))
+		r.Use(Authorize(policy))
+
+		r.Get("/products/{id}", ok)
+		r.Patch("/products/{id}", ok)
+		r.Delete("/products/{id}", ok)
+		r.Get("/unmapped", ok)
+	})
+
+	cases := []struct {
+		name   string
+		method string
+		path   string
+		token  string
+		code   int
+	}{
+		{"viewer can read", "GET", "/products/1", "viewer-token", 204},
+		{"viewer can't write", "PATCH", "/products/1", "viewer-token", 403},
+		{"editor can write", "PATCH", "/products/1", "editor-token", 204},
+		{"editor can't delete", "DELETE", "/products/1", "editor-token", 403},
+		{"admin can delete", "DELETE", "/products/1", "admin-token", 204},
+		{"routes missing from the policy are denied", "GET", "/unmapped", "admin-token", 403},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// Act
+			req := httptest.NewRequest(c.method, c.path, nil)
+			req.Header.Set("Authorization", c.token)
+			res := httptest.NewRecorder()
+			router.ServeHTTP(res, req)
+			// Assert
+			require.Equal(t, c.code, res.Code)
+			if c.code == 403 {
+				require.Equal(t, `{"status_code":403,"message":"Forbidden","data":null}`, res.Body.String())
+			}
+		})
+	}
+
+	t.Run("should grant scopes carried by the identity itself", func(t *testing.T) {
+		id := Identity{Name: "catalog-service", Scopes: []string{"products:delete"}}
+
+		require.True(t, policy.Allows(id, "DELETE", "/products/{id}"))
+		require.False(t, policy.Allows(id, "POST", "/products"))
+	})
+}
@@ -0,0 +1,92 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"strings"
+	"web/clase1/platform/tools"
+)
+
+// Token is a named static credential granted a role
+type Token struct {
+	Name  string `json:"name"`
+	Token string `json:"token"`
+	Role  string `json:"role"`
+}
+
+// TokenStore authenticates requests against a fixed set of named tokens
+type TokenStore struct {
+	tokens []Token
+}
+
+func NewTokenStore(tokens ...Token) *TokenStore {
+	return &TokenStore{
+		tokens: tokens,
+	}
+}
+
+// LoadTokens reads a JSON array of named tokens from the env environment
+// variable when it's set and otherwise from the given file. Without either
+// no token is accepted.
+func LoadTokens(path, env string) (*TokenStore, error) {
+	var data []byte
+	if value, ok := os.LookupEnv(env); ok && env != "" {
+		data, path = []byte(value), env
+	} else {
+		var err error
+		data, err = tools.ReadFile(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			return NewTokenStore(), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var tokens []Token
+	if err := json.Unmarshal(data, &tokens); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	for _, t := range tokens {
+		if t.Name == "" || t.Token == "" {
+			return nil, fmt.Errorf("%s: %w", path, errors.New("token entries need a name and a token"))
+		}
+	}
+
+	return NewTokenStore(tokens...), nil
+}
+
+// Authenticate matches the Authorization header against the known tokens
+func (s *TokenStore) Authenticate(r *http.Request) (Identity, error) {
+	credential := Credential(r)
+	if credential == "" {
+		return Identity{}, ErrMissingCredentials
+	}
+
+	for _, t := range s.tokens {
+		if subtle.ConstantTimeCompare([]byte(credential), []byte(t.Token)) == 1 {
+			id := Identity{Name: t.Name}
+			if t.Role != "" {
+				id.Roles = []string{t.Role}
+			}
+			return id, nil
+		}
+	}
+	return Identity{}, ErrInvalidCredentials
+}
+
+// Credential returns the raw credential of the Authorization header,
+// with an optional "Bearer " prefix removed
+func Credential(r *http.Request) string {
+	header := strings.TrimSpace(r.Header.Get("Authorization"))
+	if len(header) > len("Bearer ") && strings.EqualFold(header[:len("Bearer ")], "Bearer ") {
+		return strings.TrimSpace(header[len("Bearer "):])
+	}
+	return header
+}
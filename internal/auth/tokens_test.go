@@ -0,0 +1,69 @@
+package auth
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadTokens(t *testing.T) {
+	authenticate := func(s *TokenStore, token string) (Identity, error) {
+		req := httptest.NewRequest("GET", "/products/1", nil)
+		req.Header.Set("Authorization", token)
+		return s.Authenticate(req)
+	}
+
+	t.Run("should read the tokens from the file", func(t *testing.T) {
+		// Arrange
+		path := filepath.Join(t.TempDir(), "tokens.json")
+		require.NoError(t, os.WriteFile(path, []byte(`[{"name":"support","token":"file-token","role":"viewer"}]`), 0600))
+
+		// Act
+		s, err := LoadTokens(path, "TEST_API_TOKENS_MISSING")
+		require.NoError(t, err)
+
+		// Assert
+		id, err := authenticate(s, "file-token")
+		require.NoError(t, err)
+		require.Equal(t, Identity{Name: "support", Roles: []string{"viewer"}}, id)
+	})
+	t.Run("should prefer the environment variable to the file", func(t *testing.T) {
+		// Arrange
+		path := filepath.Join(t.TempDir(), "tokens.json")
+		require.NoError(t, os.WriteFile(path, []byte(`[{"name":"support","token":"file-token","role":"viewer"}]`), 0600))
+		t.Setenv("TEST_API_TOKENS", `[{"name":"merch","token":"env-token","role":"editor"}]`)
+
+		// Act
+		s, err := LoadTokens(path, "TEST_API_TOKENS")
+		require.NoError(t, err)
+
+		// Assert
+		id, err := authenticate(s, "env-token")
+		require.NoError(t, err)
+		require.Equal(t, "merch", id.Name)
+		_, err = authenticate(s, "file-token")
+		require.ErrorIs(t, err, ErrInvalidCredentials)
+	})
+	t.Run("should accept no token when neither the variable nor the file is there", func(t *testing.T) {
+		// Act
+		s, err := LoadTokens(filepath.Join(t.TempDir(), "tokens.json"), "TEST_API_TOKENS_MISSING")
+		require.NoError(t, err)
+
+		// Assert
+		_, err = authenticate(s, "123456")
+		require.ErrorIs(t, err, ErrInvalidCredentials)
+	})
+	t.Run("should fail when an entry has no token", func(t *testing.T) {
+		// Arrange
+		t.Setenv("TEST_API_TOKENS", `[{"name":"support","role":"viewer"}]`)
+
+		// Act
+		_, err := LoadTokens(filepath.Join(t.TempDir(), "tokens.json"), "TEST_API_TOKENS")
+
+		// Assert
+		require.Error(t, err)
+	})
+}
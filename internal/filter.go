@@ -0,0 +1,67 @@
+package product
+
+import (
+	"strings"
+	"time"
+)
+
+// ExpirationLayout is the dd/mm/yyyy format of Product.Expiration
+const ExpirationLayout = "02/01/2006"
+
+// ExpirationDate parses Expiration
+func (p Product) ExpirationDate() (time.Time, error) {
+	return time.Parse(ExpirationLayout, p.Expiration)
+}
+
+// Filter selects products, every field set must match. The zero Filter
+// matches every product.
+type Filter struct {
+	// ranges are inclusive but for PriceGt
+	PriceGt     *float64
+	PriceGte    *float64
+	PriceLte    *float64
+	QuantityGte *int
+	QuantityLte *int
+	IsPublished *bool
+	// CodePrefix matches code values starting with it
+	CodePrefix string
+	// NameContains matches names containing it, ignoring case
+	NameContains string
+	// ExpirationBefore and ExpirationAfter are exclusive bounds, products
+	// whose expiration doesn't parse match neither
+	ExpirationBefore *time.Time
+	ExpirationAfter  *time.Time
+}
+
+// IsZero reports whether f has no field set
+func (f Filter) IsZero() bool {
+	return f == Filter{}
+}
+
+// Match reports whether p is selected by f. Repositories filtering their own
+// way, like in SQL, must agree with it.
+func (f Filter) Match(p Product) bool {
+	switch {
+	case f.PriceGt != nil && p.Price <= *f.PriceGt,
+		f.PriceGte != nil && p.Price < *f.PriceGte,
+		f.PriceLte != nil && p.Price > *f.PriceLte,
+		f.QuantityGte != nil && p.Quantity < *f.QuantityGte,
+		f.QuantityLte != nil && p.Quantity > *f.QuantityLte,
+		f.IsPublished != nil && p.Is_Published != *f.IsPublished,
+		!strings.HasPrefix(p.CodeValue, f.CodePrefix),
+		!strings.Contains(strings.ToLower(p.Name), strings.ToLower(f.NameContains)):
+		return false
+	}
+
+	if f.ExpirationBefore == nil && f.ExpirationAfter == nil {
+		return true
+	}
+	expiration, err := p.ExpirationDate()
+	if err != nil {
+		return false
+	}
+	if f.ExpirationBefore != nil && !expiration.Before(*f.ExpirationBefore) {
+		return false
+	}
+	return f.ExpirationAfter == nil || expiration.After(*f.ExpirationAfter)
+}
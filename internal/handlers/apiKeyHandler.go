@@ -0,0 +1,174 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"web/clase1/internal/auth"
+	"web/clase1/internal/web"
+
+	"github.com/bootcamp-go/web/request"
+	"github.com/bootcamp-go/web/response"
+	"github.com/go-chi/chi/v5"
+)
+
+type APIKeyHandler struct {
+	Store  *auth.APIKeyStore
+	Policy *auth.Policy
+}
+
+func NewAPIKeyHandler(store *auth.APIKeyStore, policy *auth.Policy) *APIKeyHandler {
+	return &APIKeyHandler{
+		Store:  store,
+		Policy: policy,
+	}
+}
+
+type RequestBodyAPIKey struct {
+	Name string `json:"name"`
+	Role string `json:"role"`
+}
+
+// ResponseBodyAPIKey carries the plain key, which is only shown once
+type ResponseBodyAPIKey struct {
+	Key string `json:"key"`
+	*auth.APIKey
+}
+
+// ListAPIKeys returns every issued api key without its secret
+func (h *APIKeyHandler) ListAPIKeys() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body := web.StandarResponse{
+			StatusCode: http.StatusOK,
+			Message:    "API keys found",
+			Data:       h.Store.List(),
+		}
+		response.JSON(w, http.StatusOK, body)
+	}
+}
+
+// CreateAPIKey issues a new api key for a team
+func (h *APIKeyHandler) CreateAPIKey() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req RequestBodyAPIKey
+		if err := request.JSON(r, &req); err != nil {
+			body := web.StandarResponse{
+				StatusCode: http.StatusBadRequest,
+				Message:    "invalid request body",
+			}
+			response.JSON(w, http.StatusBadRequest, body)
+			return
+		}
+
+		if req.Name == "" {
+			body := web.StandarResponse{
+				StatusCode: http.StatusBadRequest,
+				Message:    "name: field is required",
+			}
+			response.JSON(w, http.StatusBadRequest, body)
+			return
+		}
+		if _, ok := h.Policy.Roles[req.Role]; !ok {
+			body := web.StandarResponse{
+				StatusCode: http.StatusBadRequest,
+				Message:    "role: unknown role",
+			}
+			response.JSON(w, http.StatusBadRequest, body)
+			return
+		}
+
+		key, k, err := h.Store.Create(req.Name, req.Role)
+		if err != nil {
+			body := web.StandarResponse{
+				StatusCode: http.StatusInternalServerError,
+				Message:    "internal server error",
+			}
+			response.JSON(w, http.StatusInternalServerError, body)
+			return
+		}
+
+		body := web.StandarResponse{
+			StatusCode: http.StatusCreated,
+			Message:    "API key created",
+			Data:       ResponseBodyAPIKey{Key: key, APIKey: k},
+		}
+		response.JSON(w, http.StatusCreated, body)
+	}
+}
+
+// RevokeAPIKey disables an api key
+func (h *APIKeyHandler) RevokeAPIKey() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(chi.URLParam(r, "id"))
+		if err != nil {
+			body := web.StandarResponse{
+				StatusCode: http.StatusBadRequest,
+				Message:    "invalid id",
+			}
+			response.JSON(w, http.StatusBadRequest, body)
+			return
+		}
+
+		if err := h.Store.Revoke(id); err != nil {
+			apiKeyError(w, err)
+			return
+		}
+
+		body := web.StandarResponse{
+			StatusCode: http.StatusNoContent,
+			Message:    "API key revoked",
+		}
+		response.JSON(w, http.StatusNoContent, body)
+	}
+}
+
+// RotateAPIKey replaces the secret of an api key
+func (h *APIKeyHandler) RotateAPIKey() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(chi.URLParam(r, "id"))
+		if err != nil {
+			body := web.StandarResponse{
+				StatusCode: http.StatusBadRequest,
+				Message:    "invalid id",
+			}
+			response.JSON(w, http.StatusBadRequest, body)
+			return
+		}
+
+		key, k, err := h.Store.Rotate(id)
+		if err != nil {
+			apiKeyError(w, err)
+			return
+		}
+
+		body := web.StandarResponse{
+			StatusCode: http.StatusOK,
+			Message:    "API key rotated",
+			Data:       ResponseBodyAPIKey{Key: key, APIKey: k},
+		}
+		response.JSON(w, http.StatusOK, body)
+	}
+}
+
+func apiKeyError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, auth.ErrAPIKeyNotFound):
+		body := web.StandarResponse{
+			StatusCode: http.StatusNotFound,
+			Message:    err.Error(),
+		}
+		response.JSON(w, http.StatusNotFound, body)
+	case errors.Is(err, auth.ErrAPIKeyRevoked):
+		body := web.StandarResponse{
+			StatusCode: http.StatusConflict,
+			Message:    err.Error(),
+		}
+		response.JSON(w, http.StatusConflict, body)
+	default:
+		body := web.StandarResponse{
+			StatusCode: http.StatusInternalServerError,
+			Message:    "internal server error",
+		}
+		response.JSON(w, http.StatusInternalServerError, body)
+	}
+}
@@ -0,0 +1,295 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"web/clase1/internal/auth"
+	"web/clase1/internal/storage"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestAPIKeyHandler returns a handler over an empty in memory key store
+// and a policy with the editor role
+func newTestAPIKeyHandler(t *testing.T) *APIKeyHandler {
+	t.Helper()
+
+	store, err := auth.NewAPIKeyStore(storage.NewStorageMemory([]byte("[]")))
+	require.NoError(t, err)
+	policy := &auth.Policy{Roles: map[string][]string{"editor": {"products:write"}}}
+	return NewAPIKeyHandler(store, policy)
+}
+
+// withId sets the id route parameter of req
+func withId(req *http.Request, id string) *http.Request {
+	chiCtx := chi.NewRouteContext()
+	chiCtx.URLParams.Add("id", id)
+	return req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, chiCtx))
+}
+
+// authenticateAPIKey reports whether the store of hd accepts key
+func authenticateAPIKey(hd *APIKeyHandler, key string) error {
+	req := httptest.NewRequest("GET", "/products/1", nil)
+	req.Header.Set("Authorization", key)
+	_, err := hd.Store.Authenticate(req)
+	return err
+}
+
+// issuedKey is the data of the response to a create or a rotation
+type issuedKey struct {
+	Key    string `json:"key"`
+	Id     int    `json:"id"`
+	Name   string `json:"name"`
+	Role   string `json:"role"`
+	Prefix string `json:"prefix"`
+}
+
+func decodeIssuedKey(t *testing.T, res *httptest.ResponseRecorder) issuedKey {
+	t.Helper()
+
+	var body struct {
+		Data issuedKey `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(res.Body.Bytes(), &body))
+	return body.Data
+}
+
+func TestListAPIKeys(t *testing.T) {
+	t.Run("should return an empty list when no key was issued", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		hd := newTestAPIKeyHandler(t)
+		// Act
+		req := httptest.NewRequest("GET", "/admin/api-keys", nil)
+		res := httptest.NewRecorder()
+		hdFunc := hd.ListAPIKeys()
+		hdFunc(res, req)
+		// Assert
+		expectedBody := `{"status_code":200,"message":"API keys found","data":[]}`
+
+		require.Equal(t, 200, res.Code)
+		require.Equal(t, expectedBody, res.Body.String())
+		require.Equal(t, "application/json", res.Header().Get("Content-Type"))
+	})
+	t.Run("should return the issued keys without their secrets", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		hd := newTestAPIKeyHandler(t)
+		key, _, err := hd.Store.Create("merchandising", "editor")
+		require.NoError(t, err)
+		_, revoked, err := hd.Store.Create("pricing", "editor")
+		require.NoError(t, err)
+		require.NoError(t, hd.Store.Revoke(revoked.Id))
+		// Act
+		req := httptest.NewRequest("GET", "/admin/api-keys", nil)
+		res := httptest.NewRecorder()
+		hdFunc := hd.ListAPIKeys()
+		hdFunc(res, req)
+		// Assert
+		var body struct {
+			Data []map[string]any `json:"data"`
+		}
+		require.NoError(t, json.Unmarshal(res.Body.Bytes(), &body))
+
+		require.Equal(t, 200, res.Code)
+		require.NotContains(t, res.Body.String(), key)
+		require.NotContains(t, res.Body.String(), "hash")
+		require.Len(t, body.Data, 2)
+		require.Equal(t, "merchandising", body.Data[0]["name"])
+		require.Nil(t, body.Data[0]["revoked_at"])
+		require.Equal(t, "pricing", body.Data[1]["name"])
+		require.NotNil(t, body.Data[1]["revoked_at"])
+	})
+}
+
+func TestCreateAPIKey(t *testing.T) {
+	t.Run("should create a key that authenticates", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		hd := newTestAPIKeyHandler(t)
+		// Act
+		req := httptest.NewRequest("POST", "/admin/api-keys", strings.NewReader(`{"name":"merchandising","role":"editor"}`))
+		req.Header.Set("Content-Type", "application/json")
+		res := httptest.NewRecorder()
+		hdFunc := hd.CreateAPIKey()
+		hdFunc(res, req)
+		// Assert
+		key := decodeIssuedKey(t, res)
+
+		require.Equal(t, 201, res.Code)
+		require.Equal(t, issuedKey{Key: key.Key, Id: 1, Name: "merchandising", Role: "editor", Prefix: key.Prefix}, key)
+		require.True(t, strings.HasPrefix(key.Key, "ak_"))
+		require.True(t, strings.HasPrefix(key.Key, key.Prefix))
+		require.NoError(t, authenticateAPIKey(hd, key.Key))
+	})
+	t.Run("should return a bad request when the body is invalid", func(t *testing.T) {
+		t.Parallel()
+		for reqBody, message := range map[string]string{
+			`{"name":`:                 "invalid request body",
+			`{"role":"editor"}`:        "name: field is required",
+			`{"name":"merchandising"}`: "role: unknown role",
+			`{"name":"merchandising","role":"owner"}`: "role: unknown role",
+		} {
+			// Arrange
+			hd := newTestAPIKeyHandler(t)
+			// Act
+			req := httptest.NewRequest("POST", "/admin/api-keys", strings.NewReader(reqBody))
+			req.Header.Set("Content-Type", "application/json")
+			res := httptest.NewRecorder()
+			hdFunc := hd.CreateAPIKey()
+			hdFunc(res, req)
+			// Assert
+			expectedBody := `{"status_code":400,"message":"` + message + `","data":null}`
+
+			require.Equal(t, 400, res.Code)
+			require.Equal(t, expectedBody, res.Body.String())
+			require.Empty(t, hd.Store.List())
+		}
+	})
+}
+
+func TestRevokeAPIKey(t *testing.T) {
+	t.Run("should revoke a key", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		hd := newTestAPIKeyHandler(t)
+		key, k, err := hd.Store.Create("merchandising", "editor")
+		require.NoError(t, err)
+		// Act
+		req := withId(httptest.NewRequest("DELETE", "/admin/api-keys/1", nil), "1")
+		res := httptest.NewRecorder()
+		hdFunc := hd.RevokeAPIKey()
+		hdFunc(res, req)
+		// Assert
+		expectedBody := `{"status_code":204,"message":"API key revoked","data":null}`
+
+		require.Equal(t, 1, k.Id)
+		require.Equal(t, 204, res.Code)
+		require.Equal(t, expectedBody, res.Body.String())
+		require.ErrorIs(t, authenticateAPIKey(hd, key), auth.ErrInvalidCredentials)
+	})
+	t.Run("should return a bad request when id is not a number", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		hd := newTestAPIKeyHandler(t)
+		// Act
+		req := withId(httptest.NewRequest("DELETE", "/admin/api-keys/one", nil), "one")
+		res := httptest.NewRecorder()
+		hdFunc := hd.RevokeAPIKey()
+		hdFunc(res, req)
+		// Assert
+		expectedBody := `{"status_code":400,"message":"invalid id","data":null}`
+
+		require.Equal(t, 400, res.Code)
+		require.Equal(t, expectedBody, res.Body.String())
+	})
+	t.Run("should return a not found when id is not found", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		hd := newTestAPIKeyHandler(t)
+		// Act
+		req := withId(httptest.NewRequest("DELETE", "/admin/api-keys/42", nil), "42")
+		res := httptest.NewRecorder()
+		hdFunc := hd.RevokeAPIKey()
+		hdFunc(res, req)
+		// Assert
+		expectedBody := `{"status_code":404,"message":"api key not found","data":null}`
+
+		require.Equal(t, 404, res.Code)
+		require.Equal(t, expectedBody, res.Body.String())
+	})
+	t.Run("should return a conflict when the key is already revoked", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		hd := newTestAPIKeyHandler(t)
+		_, k, err := hd.Store.Create("merchandising", "editor")
+		require.NoError(t, err)
+		require.NoError(t, hd.Store.Revoke(k.Id))
+		// Act
+		req := withId(httptest.NewRequest("DELETE", "/admin/api-keys/1", nil), "1")
+		res := httptest.NewRecorder()
+		hdFunc := hd.RevokeAPIKey()
+		hdFunc(res, req)
+		// Assert
+		expectedBody := `{"status_code":409,"message":"api key is revoked","data":null}`
+
+		require.Equal(t, 409, res.Code)
+		require.Equal(t, expectedBody, res.Body.String())
+	})
+}
+
+func TestRotateAPIKey(t *testing.T) {
+	t.Run("should replace the secret of a key", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		hd := newTestAPIKeyHandler(t)
+		old, _, err := hd.Store.Create("merchandising", "editor")
+		require.NoError(t, err)
+		// Act
+		req := withId(httptest.NewRequest("POST", "/admin/api-keys/1/rotate", nil), "1")
+		res := httptest.NewRecorder()
+		hdFunc := hd.RotateAPIKey()
+		hdFunc(res, req)
+		// Assert
+		key := decodeIssuedKey(t, res)
+
+		require.Equal(t, 200, res.Code)
+		require.Equal(t, issuedKey{Key: key.Key, Id: 1, Name: "merchandising", Role: "editor", Prefix: key.Prefix}, key)
+		require.NotEqual(t, old, key.Key)
+		require.NoError(t, authenticateAPIKey(hd, key.Key))
+		require.ErrorIs(t, authenticateAPIKey(hd, old), auth.ErrInvalidCredentials)
+	})
+	t.Run("should return a bad request when id is not a number", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		hd := newTestAPIKeyHandler(t)
+		// Act
+		req := withId(httptest.NewRequest("POST", "/admin/api-keys/one/rotate", nil), "one")
+		res := httptest.NewRecorder()
+		hdFunc := hd.RotateAPIKey()
+		hdFunc(res, req)
+		// Assert
+		expectedBody := `{"status_code":400,"message":"invalid id","data":null}`
+
+		require.Equal(t, 400, res.Code)
+		require.Equal(t, expectedBody, res.Body.String())
+	})
+	t.Run("should return a not found when id is not found", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		hd := newTestAPIKeyHandler(t)
+		// Act
+		req := withId(httptest.NewRequest("POST", "/admin/api-keys/42/rotate", nil), "42")
+		res := httptest.NewRecorder()
+		hdFunc := hd.RotateAPIKey()
+		hdFunc(res, req)
+		// Assert
+		expectedBody := `{"status_code":404,"message":"api key not found","data":null}`
+
+		require.Equal(t, 404, res.Code)
+		require.Equal(t, expectedBody, res.Body.String())
+	})
+	t.Run("should return a conflict when the key is revoked", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		hd := newTestAPIKeyHandler(t)
+		_, k, err := hd.Store.Create("merchandising", "editor")
+		require.NoError(t, err)
+		require.NoError(t, hd.Store.Revoke(k.Id))
+		// Act
+		req := withId(httptest.NewRequest("POST", "/admin/api-keys/1/rotate", nil), "1")
+		res := httptest.NewRecorder()
+		hdFunc := hd.RotateAPIKey()
+		hdFunc(res, req)
+		// Assert
+		expectedBody := `{"status_code":409,"message":"api key is revoked","data":null}`
+
+		require.Equal(t, 409, res.Code)
+		require.Equal(t, expectedBody, res.Body.String())
+	})
+}
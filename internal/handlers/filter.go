@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"math"
+	"net/url"
+	"slices"
+	"strconv"
+	"time"
+	product "web/clase1/internal"
+	"web/clase1/platform/tools"
+)
+
+// filterParam sets the product.Filter field of a query parameter accepted
+// by GET /products
+type filterParam struct {
+	set func(f *product.Filter, value string) error
+	// invalid is the message for values set rejects
+	invalid string
+}
+
+const invalidDate = "must be a date like 2006-01-02 or 02/01/2006"
+
+var filterParams = map[string]filterParam{
+	"price_gte": {func(f *product.Filter, value string) (err error) {
+		f.PriceGte, err = parseParam(value, parseFloat)
+		return
+	}, "must be a number"},
+	"price_lte": {func(f *product.Filter, value string) (err error) {
+		f.PriceLte, err = parseParam(value, parseFloat)
+		return
+	}, "must be a number"},
+	"quantity_gte": {func(f *product.Filter, value string) (err error) {
+		f.QuantityGte, err = parseParam(value, strconv.Atoi)
+		return
+	}, "must be an integer"},
+	"quantity_lte": {func(f *product.Filter, value string) (err error) {
+		f.QuantityLte, err = parseParam(value, strconv.Atoi)
+		return
+	}, "must be an integer"},
+	"is_published": {func(f *product.Filter, value string) (err error) {
+		f.IsPublished, err = parseParam(value, strconv.ParseBool)
+		return
+	}, "must be true or false"},
+	"code_value_prefix": {set: func(f *product.Filter, value string) error {
+		f.CodePrefix = value
+		return nil
+	}},
+	"name_contains": {set: func(f *product.Filter, value string) error {
+		f.NameContains = value
+		return nil
+	}},
+	"expiration_before": {func(f *product.Filter, value string) (err error) {
+		f.ExpirationBefore, err = parseParam(value, parseDate)
+		return
+	}, invalidDate},
+	"expiration_after": {func(f *product.Filter, value string) (err error) {
+		f.ExpirationAfter, err = parseParam(value, parseDate)
+		return
+	}, invalidDate},
+}
+
+// parseFilter reads the filter of GET /products, skipping the sort and page
+// parameters. Errors are a
+// *tools.FieldError naming the first invalid parameter in alphabetical
+// order, unknown parameters included.
+func parseFilter(query url.Values) (product.Filter, error) {
+	var f product.Filter
+
+	params := make([]string, 0, len(query))
+	for param := range query {
+		params = append(params, param)
+	}
+	slices.Sort(params)
+
+	for _, param := range params {
+		if pageParams[param] {
+			continue
+		}
+		p, ok := filterParams[param]
+		if !ok {
+			return f, &tools.FieldError{Field: param, Msg: "unknown query parameter"}
+		}
+		values := query[param]
+		if len(values) > 1 {
+			return f, &tools.FieldError{Field: param, Msg: "must be given once"}
+		}
+		if err := p.set(&f, values[0]); err != nil {
+			return f, &tools.FieldError{Field: param, Msg: p.invalid}
+		}
+	}
+	return f, nil
+}
+
+// parseParam returns a pointer to the parsed value, so the filter tells an
+// unset parameter from a zero one
+func parseParam[T any](value string, parse func(string) (T, error)) (*T, error) {
+	v, err := parse(value)
+	if err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+// parseFloat rejects NaN, no price compares to it
+func parseFloat(value string) (float64, error) {
+	v, err := strconv.ParseFloat(value, 64)
+	if err == nil && math.IsNaN(v) {
+		err = strconv.ErrSyntax
+	}
+	return v, err
+}
+
+// parseDate accepts ISO dates and the layout of Product.Expiration
+func parseDate(value string) (time.Time, error) {
+	if t, err := time.Parse(time.DateOnly, value); err == nil {
+		return t, nil
+	}
+	return time.Parse(product.ExpirationLayout, value)
+}
@@ -0,0 +1,173 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	product "web/clase1/internal"
+	"web/clase1/internal/web"
+	"web/clase1/platform/tools"
+)
+
+const (
+	// defaultLimit is the page size when only offset or cursor is given
+	defaultLimit = 100
+	maxLimit     = 1000
+)
+
+// pageParams are the query parameters ordering and paginating a listing,
+// parseFilter leaves them to parsePage
+var pageParams = map[string]bool{"limit": true, "offset": true, "cursor": true, "sort": true}
+
+// parsePage reads the order and pagination of a listing, reporting whether
+// the request asked for a pagination. Errors are a *tools.FieldError naming
+// the invalid parameter.
+func parsePage(query url.Values) (product.Page, bool, error) {
+	var page product.Page
+	if query.Has("sort") {
+		sort, err := parseSort(query["sort"])
+		if err != nil {
+			return page, false, err
+		}
+		page.Sort = sort
+	}
+	if !query.Has("limit") && !query.Has("offset") && !query.Has("cursor") {
+		return page, false, nil
+	}
+
+	page.Limit = defaultLimit
+	if query.Has("limit") {
+		limit, err := strconv.Atoi(query.Get("limit"))
+		if err != nil || limit < 1 || limit > maxLimit {
+			return page, true, &tools.FieldError{Field: "limit", Msg: "must be an integer from 1 to " + strconv.Itoa(maxLimit)}
+		}
+		page.Limit = limit
+	}
+	if query.Has("offset") {
+		if query.Has("cursor") {
+			return page, true, &tools.FieldError{Field: "offset", Msg: "can't be combined with cursor"}
+		}
+		offset, err := strconv.Atoi(query.Get("offset"))
+		if err != nil || offset < 0 {
+			return page, true, &tools.FieldError{Field: "offset", Msg: "must be a non-negative integer"}
+		}
+		page.Offset = offset
+	}
+	if query.Has("cursor") {
+		cursor, err := decodeCursor(query.Get("cursor"))
+		if err != nil {
+			return page, true, &tools.FieldError{Field: "cursor", Msg: "is not a valid cursor"}
+		}
+		page.Cursor = &cursor
+	}
+	return page, true, nil
+}
+
+// parseSort reads a sort like "-price,name": product JSON field names, each
+// descending when prefixed by "-"
+func parseSort(values []string) ([]product.SortField, error) {
+	if len(values) > 1 {
+		return nil, &tools.FieldError{Field: "sort", Msg: "must be given once"}
+	}
+
+	var sort []product.SortField
+	for _, name := range strings.Split(values[0], ",") {
+		f := product.SortField{Field: strings.TrimPrefix(name, "-")}
+		f.Desc = f.Field != name
+		if !slices.Contains(product.SortFields, f.Field) {
+			return nil, &tools.FieldError{Field: "sort", Msg: fmt.Sprintf("%q is not one of %s", name, strings.Join(product.SortFields, ", "))}
+		}
+		if slices.ContainsFunc(sort, func(s product.SortField) bool { return s.Field == f.Field }) {
+			return nil, &tools.FieldError{Field: "sort", Msg: fmt.Sprintf("%q is given twice", f.Field)}
+		}
+		sort = append(sort, f)
+	}
+	return sort, nil
+}
+
+// encodeCursor makes the opaque cursor of a link. Clients must not rely on
+// its content, only pass it back.
+func encodeCursor(c product.Cursor) string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(s string) (c product.Cursor, err error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, err
+	}
+	err = json.Unmarshal(data, &c)
+	return c, err
+}
+
+// cursorAt is the forward cursor continuing a listing after its i-th
+// product, from its score in a ranked one
+func cursorAt(result product.ProductPage, i int) product.Cursor {
+	c := product.Cursor{Key: result.Products[i]}
+	if result.Scores != nil {
+		c.Score = result.Scores[i]
+	}
+	return c
+}
+
+// pagination builds the totals and links of a page of the listing at u,
+// keeping its other query parameters. Links page the way the request did,
+// by offset or by cursor.
+func pagination(u *url.URL, page product.Page, result product.ProductPage) *web.Pagination {
+	p := &web.Pagination{Total: result.Total, Limit: page.Limit}
+
+	link := func(set func(query url.Values)) string {
+		query := u.Query()
+		query.Del("offset")
+		query.Del("cursor")
+		query.Set("limit", strconv.Itoa(page.Limit))
+		set(query)
+		return u.Path + "?" + query.Encode()
+	}
+
+	if page.Cursor == nil {
+		if result.HasNext {
+			p.Next = link(func(query url.Values) {
+				query.Set("offset", strconv.Itoa(page.Offset+len(result.Products)))
+			})
+		}
+		if result.HasPrev {
+			p.Prev = link(func(query url.Values) {
+				query.Set("offset", strconv.Itoa(max(0, min(page.Offset, result.Total)-page.Limit)))
+			})
+		}
+		return p
+	}
+
+	var next, prev product.Cursor
+	if n := len(result.Products); n > 0 {
+		next = cursorAt(result, n-1)
+		prev = cursorAt(result, 0)
+		prev.Backward = true
+	} else {
+		// an empty window continues around the cursor itself: the link
+		// going its way repeats it, the other one turns it around
+		cursor := *page.Cursor
+		turned := product.Cursor{Key: cursor.Key, Backward: !cursor.Backward, Inclusive: !cursor.Inclusive}
+		next, prev = cursor, turned
+		if cursor.Backward {
+			next, prev = turned, cursor
+		}
+	}
+	if result.HasNext {
+		p.Next = link(func(query url.Values) {
+			query.Set("cursor", encodeCursor(next))
+		})
+	}
+	if result.HasPrev {
+		p.Prev = link(func(query url.Values) {
+			query.Set("cursor", encodeCursor(prev))
+		})
+	}
+	return p
+}
@@ -6,7 +6,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
-	"os"
+	"net/url"
 	"strconv"
 	product "web/clase1/internal"
 	"web/clase1/internal/web"
@@ -27,9 +27,34 @@ func NewProductHandler(service product.ProductService) *Handler {
 	}
 }
 
-// GetAllProducts returns all the products in the storage
+// GetAllProducts returns all the products in the storage, or the ones
+// selected by the filter in the query parameters, sorted and paginated when
+// asked to
 func (h *Handler) GetAllProducts() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		filter, err := parseFilter(r.URL.Query())
+		if err != nil {
+			body := web.StandarResponse{
+				StatusCode: http.StatusBadRequest,
+				Message:    err.Error(),
+			}
+			response.JSON(w, http.StatusBadRequest, body)
+			return
+		}
+		page, paginated, err := parsePage(r.URL.Query())
+		if err != nil {
+			body := web.StandarResponse{
+				StatusCode: http.StatusBadRequest,
+				Message:    err.Error(),
+			}
+			response.JSON(w, http.StatusBadRequest, body)
+			return
+		}
+		if paginated || !filter.IsZero() || len(page.Sort) > 0 {
+			h.findProducts(w, r, filter, page, paginated)
+			return
+		}
+
 		products, err := h.Service.GetAllProducts()
 		if err != nil {
 			body := web.StandarResponse{
@@ -37,6 +62,7 @@ func (h *Handler) GetAllProducts() http.HandlerFunc {
 				Message:    err.Error(),
 			}
 			response.JSON(w, http.StatusNotFound, body)
+			return
 		}
 
 		body := web.StandarResponse{
@@ -48,18 +74,33 @@ func (h *Handler) GetAllProducts() http.HandlerFunc {
 	}
 }
 
+// findProducts responds with the page of the products matched by filter,
+// an empty list when there are none
+func (h *Handler) findProducts(w http.ResponseWriter, r *http.Request, filter product.Filter, page product.Page, paginated bool) {
+	result, err := h.Service.FindProducts(filter, page)
+	if err != nil {
+		body := web.StandarResponse{
+			StatusCode: http.StatusInternalServerError,
+			Message:    "internal server error",
+		}
+		response.JSON(w, http.StatusInternalServerError, body)
+		return
+	}
+
+	body := web.StandarResponse{
+		StatusCode: http.StatusOK,
+		Message:    "Products found",
+		Data:       result.Products,
+	}
+	if paginated {
+		body.Pagination = pagination(r.URL, page, result)
+	}
+	response.JSON(w, http.StatusOK, body)
+}
+
 // GetProductById returns a product by id
 func (h *Handler) GetProductById() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		// Check for the token
-		if r.Header.Get("Authorization") != os.Getenv("TOKEN") {
-			body := web.StandarResponse{
-				StatusCode: http.StatusUnauthorized,
-				Message:    "Unauthorized",
-			}
-			response.JSON(w, http.StatusUnauthorized, body)
-			return
-		}
 		id := chi.URLParam(r, "id")
 		idInt, err := strconv.Atoi(id)
 		if err != nil {
@@ -98,6 +139,50 @@ func (h *Handler) GetProductById() http.HandlerFunc {
 	}
 }
 
+// GetProductByCode returns a product by code value
+func (h *Handler) GetProductByCode() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		code := chi.URLParam(r, "code_value")
+		// chi matches the escaped path when decoding changes its segments,
+		// as an escaped '/' does, codes may hold any character
+		if r.URL.RawPath != "" {
+			var err error
+			if code, err = url.PathUnescape(code); err != nil {
+				body := web.StandarResponse{
+					StatusCode: http.StatusBadRequest,
+					Message:    "Bad request",
+				}
+				response.JSON(w, http.StatusBadRequest, body)
+				return
+			}
+		}
+
+		p, err := h.Service.GetProductByCode(code)
+		if err != nil {
+			if errors.Is(err, product.ErrProdNotFound) {
+				body := web.StandarResponse{
+					StatusCode: http.StatusNotFound,
+					Message:    err.Error(),
+				}
+				response.JSON(w, http.StatusNotFound, body)
+				return
+			}
+			body := web.StandarResponse{
+				StatusCode: http.StatusInternalServerError,
+				Message:    "internal server error",
+			}
+			response.JSON(w, http.StatusInternalServerError, body)
+			return
+		}
+		body := web.StandarResponse{
+			StatusCode: http.StatusOK,
+			Message:    "Product found",
+			Data:       p,
+		}
+		response.JSON(w, http.StatusOK, body)
+	}
+}
+
 // GetProductsByPriceGt returns a list of products with a price greater than the one specified in the query
 func (h *Handler) GetProductsByPriceGt() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -108,6 +193,20 @@ func (h *Handler) GetProductsByPriceGt() http.HandlerFunc {
 			return
 		}
 
+		page, paginated, err := parsePage(r.URL.Query())
+		if err != nil {
+			body := web.StandarResponse{
+				StatusCode: http.StatusBadRequest,
+				Message:    err.Error(),
+			}
+			response.JSON(w, http.StatusBadRequest, body)
+			return
+		}
+		if paginated || len(page.Sort) > 0 {
+			h.findProducts(w, r, product.Filter{PriceGt: &priceFloat}, page, paginated)
+			return
+		}
+
 		products := h.Service.FindProductsByPriceGt(priceFloat)
 		body := web.StandarResponse{
 			StatusCode: http.StatusOK,
@@ -118,19 +217,51 @@ func (h *Handler) GetProductsByPriceGt() http.HandlerFunc {
 	}
 }
 
-// CreateProduct creates a new product
-func (h *Handler) CreateProduct() http.HandlerFunc {
+// SearchProducts returns the products whose names match the q query
+// parameter, even partially or misspelled, the most relevant first. Results
+// are always paginated, see parseSearch. Without q it searches by price
+// like GetProductsByPriceGt.
+func (h *Handler) SearchProducts() http.HandlerFunc {
+	byPrice := h.GetProductsByPriceGt()
 	return func(w http.ResponseWriter, r *http.Request) {
-		// Check for the token
-		if r.Header.Get("Authorization") != os.Getenv("TOKEN") {
+		if !r.URL.Query().Has("q") {
+			byPrice(w, r)
+			return
+		}
+
+		q, page, err := parseSearch(r.URL.Query())
+		if err != nil {
 			body := web.StandarResponse{
-				StatusCode: http.StatusUnauthorized,
-				Message:    "Unauthorized",
+				StatusCode: http.StatusBadRequest,
+				Message:    err.Error(),
 			}
-			response.JSON(w, http.StatusUnauthorized, body)
+			response.JSON(w, http.StatusBadRequest, body)
 			return
 		}
 
+		result, err := h.Service.SearchProducts(q, page)
+		if err != nil {
+			body := web.StandarResponse{
+				StatusCode: http.StatusInternalServerError,
+				Message:    "internal server error",
+			}
+			response.JSON(w, http.StatusInternalServerError, body)
+			return
+		}
+
+		body := web.StandarResponse{
+			StatusCode: http.StatusOK,
+			Message:    "Products found",
+			Data:       result.Products,
+			Pagination: pagination(r.URL, page, result),
+		}
+		response.JSON(w, http.StatusOK, body)
+	}
+}
+
+// CreateProduct creates a new product
+func (h *Handler) CreateProduct() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
 		bytes, err := io.ReadAll(r.Body)
 		if err != nil {
 			body := web.StandarResponse{
@@ -180,7 +311,16 @@ func (h *Handler) CreateProduct() http.HandlerFunc {
 			return
 		}
 
-		if err = h.Service.CreateProduct(&p); err != nil {
+		if err = h.Service.CreateProduct(r.Context(), &p); err != nil {
+			if errors.Is(err, product.ErrProdConflict) || errors.Is(err, product.ErrProdDuplicateCode) {
+				body := web.StandarResponse{
+					StatusCode: http.StatusConflict,
+					Message:    err.Error(),
+				}
+				response.JSON(w, http.StatusConflict, body)
+				return
+			}
+
 			body := web.StandarResponse{
 				StatusCode: http.StatusBadRequest,
 				Message:    err.Error(),
@@ -201,16 +341,6 @@ func (h *Handler) CreateProduct() http.HandlerFunc {
 // UpdateOrCreateProduct updates a product or creates it if it doesn't exist
 func (h *Handler) UpdateOrCreateProduct() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		// Check for the token
-		if r.Header.Get("Authorization") != os.Getenv("TOKEN") {
-			body := web.StandarResponse{
-				StatusCode: http.StatusUnauthorized,
-				Message:    "Unauthorized",
-			}
-			response.JSON(w, http.StatusUnauthorized, body)
-			return
-		}
-
 		id := chi.URLParam(r, "id")
 		idInt, err := strconv.Atoi(id)
 		if err != nil {
@@ -272,7 +402,16 @@ func (h *Handler) UpdateOrCreateProduct() http.HandlerFunc {
 			return
 		}
 
-		if err = h.Service.UpdateOrCreateProduct(&p, idInt); err != nil {
+		if err = h.Service.UpdateOrCreateProduct(r.Context(), &p, idInt); err != nil {
+			if errors.Is(err, product.ErrProdConflict) || errors.Is(err, product.ErrProdDuplicateCode) {
+				body := web.StandarResponse{
+					StatusCode: http.StatusConflict,
+					Message:    err.Error(),
+				}
+				response.JSON(w, http.StatusConflict, body)
+				return
+			}
+
 			body := web.StandarResponse{
 				StatusCode: http.StatusBadRequest,
 				Message:    err.Error(),
@@ -296,16 +435,6 @@ func (h *Handler) UpdateOrCreateProduct() http.HandlerFunc {
 // UpdatePartial updates a product partially
 func (h *Handler) UpdatePartial() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		// Check for the token
-		if r.Header.Get("Authorization") != os.Getenv("TOKEN") {
-			body := web.StandarResponse{
-				StatusCode: http.StatusUnauthorized,
-				Message:    "Unauthorized",
-			}
-			response.JSON(w, http.StatusUnauthorized, body)
-			return
-		}
-
 		id, err := strconv.Atoi(chi.URLParam(r, "id"))
 		if err != nil {
 			body := web.StandarResponse{
@@ -326,7 +455,7 @@ func (h *Handler) UpdatePartial() http.HandlerFunc {
 			return
 		}
 
-		if err := h.Service.UpdatePartial(bodyMap, id); err != nil {
+		if err := h.Service.UpdatePartial(r.Context(), bodyMap, id); err != nil {
 			switch {
 			case errors.Is(err, product.ErrProdNotFound):
 				body := web.StandarResponse{
@@ -340,6 +469,12 @@ func (h *Handler) UpdatePartial() http.HandlerFunc {
 					Message:    err.Error(),
 				}
 				response.JSON(w, http.StatusBadRequest, body)
+			case errors.Is(err, product.ErrProdConflict), errors.Is(err, product.ErrProdDuplicateCode):
+				body := web.StandarResponse{
+					StatusCode: http.StatusConflict,
+					Message:    err.Error(),
+				}
+				response.JSON(w, http.StatusConflict, body)
 			default:
 				body := web.StandarResponse{
 					StatusCode: http.StatusInternalServerError,
@@ -347,6 +482,7 @@ func (h *Handler) UpdatePartial() http.HandlerFunc {
 				}
 				response.JSON(w, http.StatusInternalServerError, body)
 			}
+			return
 		}
 		body := web.StandarResponse{
 			StatusCode: http.StatusNoContent,
@@ -359,16 +495,6 @@ func (h *Handler) UpdatePartial() http.HandlerFunc {
 // DeleteProduct deletes a product
 func (h *Handler) DeleteProduct() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		// Check for the token
-		if r.Header.Get("Authorization") != os.Getenv("TOKEN") {
-			body := web.StandarResponse{
-				StatusCode: http.StatusUnauthorized,
-				Message:    "Unauthorized",
-			}
-			response.JSON(w, http.StatusUnauthorized, body)
-			return
-		}
-
 		id, err := strconv.Atoi(chi.URLParam(r, "id"))
 		if err != nil {
 			body := web.StandarResponse{
@@ -379,7 +505,7 @@ func (h *Handler) DeleteProduct() http.HandlerFunc {
 			return
 		}
 
-		if err := h.Service.DeleteProduct(id); err != nil {
+		if err := h.Service.DeleteProduct(r.Context(), id); err != nil {
 			switch {
 			case errors.Is(err, product.ErrProdNotFound):
 				body := web.StandarResponse{
@@ -393,6 +519,12 @@ func (h *Handler) DeleteProduct() http.HandlerFunc {
 					Message:    err.Error(),
 				}
 				response.JSON(w, http.StatusBadRequest, body)
+			case errors.Is(err, product.ErrProdConflict):
+				body := web.StandarResponse{
+					StatusCode: http.StatusConflict,
+					Message:    err.Error(),
+				}
+				response.JSON(w, http.StatusConflict, body)
 			default:
 				body := web.StandarResponse{
 					StatusCode: http.StatusInternalServerError,
@@ -416,16 +548,6 @@ func (h *Handler) DeleteProduct() http.HandlerFunc {
 // TODO: fix update quantity--
 func (h *Handler) GetConsumerPrice() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		// Check for the token
-		if r.Header.Get("Authorization") != os.Getenv("TOKEN") {
-			body := web.StandarResponse{
-				StatusCode: http.StatusUnauthorized,
-				Message:    "Unauthorized",
-			}
-			response.JSON(w, http.StatusUnauthorized, body)
-			return
-		}
-
 		strIds := r.URL.Query()["list"]
 		var ids []int
 
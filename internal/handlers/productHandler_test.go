@@ -2,22 +2,37 @@ package handlers
 
 import (
 	"context"
+	"encoding/json"
+	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	product "web/clase1/internal"
 	"web/clase1/internal/repository"
+	"web/clase1/internal/repository/repositorytest"
 	"web/clase1/internal/service"
-	"web/clase1/internal/storage"
+	"web/clase1/internal/web"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/stretchr/testify/require"
 )
 
+// testCatalog is the catalog most handler tests start from
+func testCatalog() *repositorytest.Fixture {
+	return repositorytest.NewFixture().With(
+		product.Product{Id: 1, Name: "Oil - Margarine", Quantity: 439, CodeValue: "S82254D", Is_Published: true, Expiration: "15/12/2021", Price: 71.42},
+		product.Product{Id: 2, Name: "Pineapple - Canned, Rings", Quantity: 345, CodeValue: "M4637", Is_Published: true, Expiration: "09/08/2021", Price: 352.79},
+		product.Product{Id: 3, Name: "Wine - Red Oakridge Merlot", Quantity: 367, CodeValue: "T65812", Is_Published: false, Expiration: "24/05/2021", Price: 179.23},
+	)
+}
+
 func TestGetProduct(t *testing.T) {
 	t.Run("should return all products", func(t *testing.T) {
+		t.Parallel()
 		// Arrange
-		st := storage.NewStorageJSON("../../docs/db/test_products.json")
-		rp := repository.NewProductRepository(st)
+		st := testCatalog().Storage()
+		rp, err := repository.NewProductRepository(st)
+		require.NoError(t, err)
 		sv := service.NewProductService(rp)
 		hd := NewProductHandler(sv)
 		// Act
@@ -34,11 +49,363 @@ func TestGetProduct(t *testing.T) {
 	})
 }
 
+func TestGetProductsFiltered(t *testing.T) {
+	t.Run("should return the products matching every filter", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		st := testCatalog().Storage()
+		rp, err := repository.NewProductRepository(st)
+		require.NoError(t, err)
+		sv := service.NewProductService(rp)
+		hd := NewProductHandler(sv)
+		// Act
+		req := httptest.NewRequest("GET", "/products?price_gte=100&is_published=true&expiration_after=2021-01-01", nil)
+		res := httptest.NewRecorder()
+		hdFunc := hd.GetAllProducts()
+		hdFunc(res, req)
+		// Assert
+		expectedBody := `{"status_code":200,"message":"Products found","data":[{"id":2,"name":"Pineapple - Canned, Rings","quantity":345,"code_value":"M4637","is_published":true,"expiration":"09/08/2021","price":352.79}]}`
+
+		require.Equal(t, 200, res.Code)
+		require.Equal(t, expectedBody, res.Body.String())
+	})
+	t.Run("should return an empty list when nothing matches", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		st := testCatalog().Storage()
+		rp, err := repository.NewProductRepository(st)
+		require.NoError(t, err)
+		sv := service.NewProductService(rp)
+		hd := NewProductHandler(sv)
+		// Act
+		req := httptest.NewRequest("GET", "/products?name_contains=cheese", nil)
+		res := httptest.NewRecorder()
+		hdFunc := hd.GetAllProducts()
+		hdFunc(res, req)
+		// Assert
+		expectedBody := `{"status_code":200,"message":"Products found","data":[]}`
+
+		require.Equal(t, 200, res.Code)
+		require.Equal(t, expectedBody, res.Body.String())
+	})
+	t.Run("should name the invalid parameter", func(t *testing.T) {
+		t.Parallel()
+		for query, message := range map[string]string{
+			"quantity_gte=10&price_lte=cheap": "price_lte: must be a number",
+			"expiration_before=tomorrow":      "expiration_before: must be a date like 2006-01-02 or 02/01/2006",
+			"price_gt=10":                     "price_gt: unknown query parameter",
+		} {
+			// Arrange
+			st := testCatalog().Storage()
+			rp, err := repository.NewProductRepository(st)
+			require.NoError(t, err)
+			sv := service.NewProductService(rp)
+			hd := NewProductHandler(sv)
+			// Act
+			req := httptest.NewRequest("GET", "/products?"+query, nil)
+			res := httptest.NewRecorder()
+			hdFunc := hd.GetAllProducts()
+			hdFunc(res, req)
+			// Assert
+			expectedBody := `{"status_code":400,"message":"` + message + `","data":null}`
+
+			require.Equal(t, 400, res.Code)
+			require.Equal(t, expectedBody, res.Body.String())
+		}
+	})
+}
+
+func TestGetProductsPaginated(t *testing.T) {
+	t.Run("should return a page by offset with the link to the next one", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		st := testCatalog().Storage()
+		rp, err := repository.NewProductRepository(st)
+		require.NoError(t, err)
+		sv := service.NewProductService(rp)
+		hd := NewProductHandler(sv)
+		// Act
+		req := httptest.NewRequest("GET", "/products?limit=2", nil)
+		res := httptest.NewRecorder()
+		hdFunc := hd.GetAllProducts()
+		hdFunc(res, req)
+		// Assert
+		expectedBody := `{"status_code":200,"message":"Products found","data":[{"id":1,"name":"Oil - Margarine","quantity":439,"code_value":"S82254D","is_published":true,"expiration":"15/12/2021","price":71.42},{"id":2,"name":"Pineapple - Canned, Rings","quantity":345,"code_value":"M4637","is_published":true,"expiration":"09/08/2021","price":352.79}],"pagination":{"total":3,"limit":2,"next":"/products?limit=2\u0026offset=2"}}`
+
+		require.Equal(t, 200, res.Code)
+		require.Equal(t, expectedBody, res.Body.String())
+	})
+	t.Run("should follow cursors forward and back", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		st := testCatalog().Storage()
+		rp, err := repository.NewProductRepository(st)
+		require.NoError(t, err)
+		sv := service.NewProductService(rp)
+		hd := NewProductHandler(sv)
+		get := func(target string) web.StandarResponse {
+			req := httptest.NewRequest("GET", target, nil)
+			res := httptest.NewRecorder()
+			hd.GetAllProducts()(res, req)
+			require.Equal(t, 200, res.Code)
+			var body web.StandarResponse
+			require.NoError(t, json.Unmarshal(res.Body.Bytes(), &body))
+			return body
+		}
+		// Act
+		first := get("/products?is_published=true&limit=1&cursor=" + encodeCursor(product.Cursor{}))
+		second := get(first.Pagination.Next)
+		back := get(second.Pagination.Prev)
+		// Assert
+		require.Equal(t, 2, first.Pagination.Total)
+		require.Empty(t, first.Pagination.Prev)
+		require.Equal(t, float64(2), second.Data.([]any)[0].(map[string]any)["id"])
+		require.Empty(t, second.Pagination.Next)
+		require.Equal(t, first.Data, back.Data)
+	})
+	t.Run("should name the invalid pagination parameter", func(t *testing.T) {
+		t.Parallel()
+		for query, message := range map[string]string{
+			"limit=0":            "limit: must be an integer from 1 to 1000",
+			"offset=-1":          "offset: must be a non-negative integer",
+			"cursor=x&offset=1":  "offset: can't be combined with cursor",
+			"cursor=not-base64!": "cursor: is not a valid cursor",
+		} {
+			// Arrange
+			st := testCatalog().Storage()
+			rp, err := repository.NewProductRepository(st)
+			require.NoError(t, err)
+			sv := service.NewProductService(rp)
+			hd := NewProductHandler(sv)
+			// Act
+			req := httptest.NewRequest("GET", "/products?"+query, nil)
+			res := httptest.NewRecorder()
+			hdFunc := hd.GetAllProducts()
+			hdFunc(res, req)
+			// Assert
+			expectedBody := `{"status_code":400,"message":"` + message + `","data":null}`
+
+			require.Equal(t, 400, res.Code)
+			require.Equal(t, expectedBody, res.Body.String())
+		}
+	})
+}
+
+func TestGetProductsSorted(t *testing.T) {
+	// ids returns the ids in the data of a listing response
+	ids := func(body web.StandarResponse) []float64 {
+		ids := []float64{}
+		for _, p := range body.Data.([]any) {
+			ids = append(ids, p.(map[string]any)["id"].(float64))
+		}
+		return ids
+	}
+	get := func(t *testing.T, hdFunc http.HandlerFunc, target string) (int, web.StandarResponse) {
+		req := httptest.NewRequest("GET", target, nil)
+		res := httptest.NewRecorder()
+		hdFunc(res, req)
+		var body web.StandarResponse
+		require.NoError(t, json.Unmarshal(res.Body.Bytes(), &body))
+		return res.Code, body
+	}
+
+	t.Run("should sort by several fields", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		st := testCatalog().Storage()
+		rp, err := repository.NewProductRepository(st)
+		require.NoError(t, err)
+		sv := service.NewProductService(rp)
+		hd := NewProductHandler(sv)
+		// Act
+		code, body := get(t, hd.GetAllProducts(), "/products?sort=-is_published,-price")
+		// Assert
+		require.Equal(t, 200, code)
+		require.Equal(t, []float64{2, 1, 3}, ids(body))
+		require.Nil(t, body.Pagination)
+	})
+	t.Run("should sort the products above a price", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		st := testCatalog().Storage()
+		rp, err := repository.NewProductRepository(st)
+		require.NoError(t, err)
+		sv := service.NewProductService(rp)
+		hd := NewProductHandler(sv)
+		// Act
+		code, body := get(t, hd.GetProductsByPriceGt(), "/products/search?priceGt=100&sort=-price")
+		// Assert
+		require.Equal(t, 200, code)
+		require.Equal(t, []float64{2, 3}, ids(body))
+	})
+	t.Run("should follow cursors through the sorted listing", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		st := testCatalog().Storage()
+		rp, err := repository.NewProductRepository(st)
+		require.NoError(t, err)
+		sv := service.NewProductService(rp)
+		hd := NewProductHandler(sv)
+		// Act
+		_, first := get(t, hd.GetAllProducts(), "/products?sort=-price&limit=1&cursor="+encodeCursor(product.Cursor{Key: product.Product{Price: 1e9}}))
+		_, second := get(t, hd.GetAllProducts(), first.Pagination.Next)
+		_, third := get(t, hd.GetAllProducts(), second.Pagination.Next)
+		_, back := get(t, hd.GetAllProducts(), third.Pagination.Prev)
+		// Assert
+		require.Equal(t, []float64{2}, ids(first))
+		require.Equal(t, []float64{3}, ids(second))
+		require.Equal(t, []float64{1}, ids(third))
+		require.Empty(t, third.Pagination.Next)
+		require.Equal(t, second.Data, back.Data)
+	})
+	t.Run("should reject invalid sorts", func(t *testing.T) {
+		t.Parallel()
+		for query, message := range map[string]string{
+			"sort=color":        `sort: "color" is not one of id, name, quantity, code_value, is_published, expiration, price`,
+			"sort=price,":       `sort: "" is not one of id, name, quantity, code_value, is_published, expiration, price`,
+			"sort=price,-price": `sort: "price" is given twice`,
+			"sort=id&sort=name": "sort: must be given once",
+		} {
+			// Arrange
+			st := testCatalog().Storage()
+			rp, err := repository.NewProductRepository(st)
+			require.NoError(t, err)
+			sv := service.NewProductService(rp)
+			hd := NewProductHandler(sv)
+			// Act
+			code, body := get(t, hd.GetAllProducts(), "/products?"+query)
+			// Assert
+			require.Equal(t, 400, code)
+			require.Equal(t, message, body.Message)
+		}
+	})
+}
+
+func TestSearchProducts(t *testing.T) {
+	t.Run("should return the products named like the query, most relevant first", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		st := testCatalog().Storage()
+		rp, err := repository.NewProductRepository(st)
+		require.NoError(t, err)
+		sv := service.NewProductService(rp)
+		hd := NewProductHandler(sv)
+		// Act
+		req := httptest.NewRequest("GET", "/products/search?q=pinapple+rings", nil)
+		res := httptest.NewRecorder()
+		hdFunc := hd.SearchProducts()
+		hdFunc(res, req)
+		// Assert
+		expectedBody := `{"status_code":200,"message":"Products found","data":[{"id":2,"name":"Pineapple - Canned, Rings","quantity":345,"code_value":"M4637","is_published":true,"expiration":"09/08/2021","price":352.79}],"pagination":{"total":1,"limit":100}}`
+
+		require.Equal(t, 200, res.Code)
+		require.Equal(t, expectedBody, res.Body.String())
+	})
+	t.Run("should page the ranked products by offset", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		st := testCatalog().Storage()
+		rp, err := repository.NewProductRepository(st)
+		require.NoError(t, err)
+		sv := service.NewProductService(rp)
+		hd := NewProductHandler(sv)
+		// Act
+		req := httptest.NewRequest("GET", "/products/search?q=pineapple+rings+oil&limit=1", nil)
+		res := httptest.NewRecorder()
+		hdFunc := hd.SearchProducts()
+		hdFunc(res, req)
+		// Assert
+		expectedBody := `{"status_code":200,"message":"Products found","data":[{"id":2,"name":"Pineapple - Canned, Rings","quantity":345,"code_value":"M4637","is_published":true,"expiration":"09/08/2021","price":352.79}],"pagination":{"total":2,"limit":1,"next":"/products/search?limit=1\u0026offset=1\u0026q=pineapple+rings+oil"}}`
+
+		require.Equal(t, 200, res.Code)
+		require.Equal(t, expectedBody, res.Body.String())
+	})
+	t.Run("should follow the cursors of the ranked products forward and back", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		st := testCatalog().Storage()
+		rp, err := repository.NewProductRepository(st)
+		require.NoError(t, err)
+		sv := service.NewProductService(rp)
+		hd := NewProductHandler(sv)
+		get := func(target string) web.StandarResponse {
+			req := httptest.NewRequest("GET", target, nil)
+			res := httptest.NewRecorder()
+			hd.SearchProducts()(res, req)
+			require.Equal(t, 200, res.Code)
+			var body web.StandarResponse
+			require.NoError(t, json.Unmarshal(res.Body.Bytes(), &body))
+			return body
+		}
+		// Act
+		// a cursor scoring above any match starts the ranking
+		first := get("/products/search?q=pineapple+rings+oil&limit=1&cursor=" + encodeCursor(product.Cursor{Score: 1e9}))
+		second := get(first.Pagination.Next)
+		back := get(second.Pagination.Prev)
+		// Assert
+		require.Equal(t, 2, first.Pagination.Total)
+		require.Empty(t, first.Pagination.Prev)
+		require.Equal(t, float64(2), first.Data.([]any)[0].(map[string]any)["id"])
+		require.Equal(t, float64(1), second.Data.([]any)[0].(map[string]any)["id"])
+		require.Empty(t, second.Pagination.Next)
+		require.Equal(t, first.Data, back.Data)
+	})
+	t.Run("should search by price without a query", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		st := testCatalog().Storage()
+		rp, err := repository.NewProductRepository(st)
+		require.NoError(t, err)
+		sv := service.NewProductService(rp)
+		hd := NewProductHandler(sv)
+		// Act
+		req := httptest.NewRequest("GET", "/products/search?priceGt=300", nil)
+		res := httptest.NewRecorder()
+		hdFunc := hd.SearchProducts()
+		hdFunc(res, req)
+		// Assert
+		expectedBody := `{"status_code":200,"message":"Products found","data":[{"id":2,"name":"Pineapple - Canned, Rings","quantity":345,"code_value":"M4637","is_published":true,"expiration":"09/08/2021","price":352.79}]}`
+
+		require.Equal(t, 200, res.Code)
+		require.Equal(t, expectedBody, res.Body.String())
+	})
+	t.Run("should name the invalid search parameter", func(t *testing.T) {
+		t.Parallel()
+		for query, message := range map[string]string{
+			"q=--":            "q: must have a word to search",
+			"q=oil&q=wine":    "q: must be given once",
+			"q=oil&limit=0":   "limit: must be an integer from 1 to 1000",
+			"q=oil&priceGt=1": "priceGt: unknown query parameter",
+			"q=oil&sort=name": "sort: unknown query parameter",
+			"q=oil&offset=-1": "offset: must be a non-negative integer",
+		} {
+			// Arrange
+			st := testCatalog().Storage()
+			rp, err := repository.NewProductRepository(st)
+			require.NoError(t, err)
+			sv := service.NewProductService(rp)
+			hd := NewProductHandler(sv)
+			// Act
+			req := httptest.NewRequest("GET", "/products/search?"+query, nil)
+			res := httptest.NewRecorder()
+			hdFunc := hd.SearchProducts()
+			hdFunc(res, req)
+			// Assert
+			expectedBody := `{"status_code":400,"message":"` + message + `","data":null}`
+
+			require.Equal(t, 400, res.Code)
+			require.Equal(t, expectedBody, res.Body.String())
+		}
+	})
+}
+
 func TestGetProductById(t *testing.T) {
 	t.Run("should return a product by id", func(t *testing.T) {
+		t.Parallel()
 		// Arrange
-		st := storage.NewStorageJSON("../../docs/db/test_products.json")
-		rp := repository.NewProductRepository(st)
+		st := testCatalog().Storage()
+		rp, err := repository.NewProductRepository(st)
+		require.NoError(t, err)
 		sv := service.NewProductService(rp)
 		hd := NewProductHandler(sv)
 		// Act
@@ -59,9 +426,11 @@ func TestGetProductById(t *testing.T) {
 		require.Equal(t, "application/json", res.Header().Get("Content-Type"))
 	})
 	t.Run("should return a bad request when id is not a number", func(t *testing.T) {
+		t.Parallel()
 		// Arrange
-		st := storage.NewStorageJSON("../../docs/db/test_products.json")
-		rp := repository.NewProductRepository(st)
+		st := testCatalog().Storage()
+		rp, err := repository.NewProductRepository(st)
+		require.NoError(t, err)
 		sv := service.NewProductService(rp)
 		hd := NewProductHandler(sv)
 		// Act
@@ -82,9 +451,11 @@ func TestGetProductById(t *testing.T) {
 		require.Equal(t, "application/json", res.Header().Get("Content-Type"))
 	})
 	t.Run("should return a not found when id is not found", func(t *testing.T) {
+		t.Parallel()
 		// Arrange
-		st := storage.NewStorageJSON("../../docs/db/test_products.json")
-		rp := repository.NewProductRepository(st)
+		st := testCatalog().Storage()
+		rp, err := repository.NewProductRepository(st)
+		require.NoError(t, err)
 		sv := service.NewProductService(rp)
 		hd := NewProductHandler(sv)
 		// Act
@@ -106,11 +477,118 @@ func TestGetProductById(t *testing.T) {
 	})
 }
 
+func TestGetProductByCode(t *testing.T) {
+	t.Run("should return a product by code value", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		st := testCatalog().Storage()
+		rp, err := repository.NewProductRepository(st)
+		require.NoError(t, err)
+		sv := service.NewProductService(rp)
+		hd := NewProductHandler(sv)
+		// Act
+		req := httptest.NewRequest("GET", "/products/code/M4637", nil)
+		// Set query params with context
+		chiCtx := chi.NewRouteContext()
+		chiCtx.URLParams.Add("code_value", "M4637")
+		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, chiCtx))
+
+		res := httptest.NewRecorder()
+		hdFunc := hd.GetProductByCode()
+		hdFunc(res, req)
+		// Assert
+		expectedBody := `{"status_code":200,"message":"Product found","data":{"id":2,"name":"Pineapple - Canned, Rings","quantity":345,"code_value":"M4637","is_published":true,"expiration":"09/08/2021","price":352.79}}`
+
+		require.Equal(t, 200, res.Code)
+		require.Equal(t, expectedBody, res.Body.String())
+	})
+	t.Run("should return a not found when no product has the code value", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		st := testCatalog().Storage()
+		rp, err := repository.NewProductRepository(st)
+		require.NoError(t, err)
+		sv := service.NewProductService(rp)
+		hd := NewProductHandler(sv)
+		// Act
+		req := httptest.NewRequest("GET", "/products/code/M%204637", nil)
+		// Set query params with context
+		chiCtx := chi.NewRouteContext()
+		chiCtx.URLParams.Add("code_value", "M 4637")
+		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, chiCtx))
+
+		res := httptest.NewRecorder()
+		hdFunc := hd.GetProductByCode()
+		hdFunc(res, req)
+		// Assert
+		expectedBody := `{"status_code":404,"message":"product not found","data":null}`
+
+		require.Equal(t, 404, res.Code)
+		require.Equal(t, expectedBody, res.Body.String())
+	})
+	t.Run("should unescape the code value of the routed path once", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		st := repositorytest.NewFixture().With(
+			product.Product{Id: 1, Name: "Percent", CodeValue: "AB%41"},
+			product.Product{Id: 2, Name: "Slash", CodeValue: "A/B"},
+		).Storage()
+		rp, err := repository.NewProductRepository(st)
+		require.NoError(t, err)
+		sv := service.NewProductService(rp)
+		hd := NewProductHandler(sv)
+		router := chi.NewRouter()
+		router.Get("/products/code/{code_value}", hd.GetProductByCode())
+		for target, name := range map[string]string{
+			"/products/code/AB%2541": "Percent",
+			"/products/code/A%2FB":   "Slash",
+		} {
+			// Act
+			req := httptest.NewRequest("GET", target, nil)
+			res := httptest.NewRecorder()
+			router.ServeHTTP(res, req)
+			// Assert
+			var body struct {
+				Data product.Product `json:"data"`
+			}
+			require.NoError(t, json.Unmarshal(res.Body.Bytes(), &body))
+
+			require.Equal(t, 200, res.Code, target)
+			require.Equal(t, name, body.Data.Name)
+		}
+	})
+}
+
 func TestCreateProduct(t *testing.T) {
+	t.Run("should return a conflict when the code value is taken", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		st := testCatalog().Storage()
+		rp, err := repository.NewProductRepository(st)
+		require.NoError(t, err)
+		sv := service.NewProductService(rp)
+		hd := NewProductHandler(sv)
+
+		body := `{"name":"Pineapple","quantity":1,"code_value":"M4637","is_published":true,"expiration":"15/12/2021","price":1}`
+
+		// Act
+		req := httptest.NewRequest("POST", "/products", strings.NewReader(body))
+		res := httptest.NewRecorder()
+		hdFunc := hd.CreateProduct()
+		hdFunc(res, req)
+
+		// Assert
+		expectedBody := `{"status_code":409,"message":"code value is already used by another product: \"M4637\"","data":null}`
+
+		require.Equal(t, 409, res.Code)
+		require.Equal(t, expectedBody, res.Body.String())
+	})
 	t.Run("should create a product", func(t *testing.T) {
+		t.Parallel()
 		// Arrange
-		st := storage.NewStorageJSON("../../docs/db/test_products.json")
-		rp := repository.NewProductRepository(st)
+		st := repositorytest.NewFixture().Storage()
+		rp, err := repository.NewProductRepository(st)
+		require.NoError(t, err)
 		sv := service.NewProductService(rp)
 		hd := NewProductHandler(sv)
 
@@ -133,9 +611,11 @@ func TestCreateProduct(t *testing.T) {
 
 func TestDeleteProduct(t *testing.T) {
 	t.Run("should delete a product", func(t *testing.T) {
+		t.Parallel()
 		// Arrange
-		st := storage.NewStorageJSON("../../docs/db/test_products.json")
-		rp := repository.NewProductRepository(st)
+		st := testCatalog().Storage()
+		rp, err := repository.NewProductRepository(st)
+		require.NoError(t, err)
 		sv := service.NewProductService(rp)
 		hd := NewProductHandler(sv)
 
@@ -158,9 +638,11 @@ func TestDeleteProduct(t *testing.T) {
 		require.Equal(t, "application/json", res.Header().Get("Content-Type"))
 	})
 	t.Run("should return a bad request when id is not a number", func(t *testing.T) {
+		t.Parallel()
 		// Arrange
-		st := storage.NewStorageJSON("../../docs/db/test_products.json")
-		rp := repository.NewProductRepository(st)
+		st := testCatalog().Storage()
+		rp, err := repository.NewProductRepository(st)
+		require.NoError(t, err)
 		sv := service.NewProductService(rp)
 		hd := NewProductHandler(sv)
 		// Act
@@ -181,9 +663,11 @@ func TestDeleteProduct(t *testing.T) {
 		require.Equal(t, "application/json", res.Header().Get("Content-Type"))
 	})
 	t.Run("should return a not found when id is not found", func(t *testing.T) {
+		t.Parallel()
 		// Arrange
-		st := storage.NewStorageJSON("../../docs/db/test_products.json")
-		rp := repository.NewProductRepository(st)
+		st := testCatalog().Storage()
+		rp, err := repository.NewProductRepository(st)
+		require.NoError(t, err)
 		sv := service.NewProductService(rp)
 		hd := NewProductHandler(sv)
 		// Act
@@ -207,9 +691,11 @@ func TestDeleteProduct(t *testing.T) {
 
 func TestUpdateOrCreateProduct(t *testing.T) {
 	t.Run("should throw a bad request when id is not a number", func(t *testing.T) {
+		t.Parallel()
 		// Arrange
-		st := storage.NewStorageJSON("../../docs/db/test_products.json")
-		rp := repository.NewProductRepository(st)
+		st := testCatalog().Storage()
+		rp, err := repository.NewProductRepository(st)
+		require.NoError(t, err)
 		sv := service.NewProductService(rp)
 		hd := NewProductHandler(sv)
 		// Act
@@ -233,9 +719,11 @@ func TestUpdateOrCreateProduct(t *testing.T) {
 
 func TestUpdatePartial(t *testing.T) {
 	t.Run("should throw a bad request when id is not a number", func(t *testing.T) {
+		t.Parallel()
 		// Arrange
-		st := storage.NewStorageJSON("../../docs/db/test_products.json")
-		rp := repository.NewProductRepository(st)
+		st := testCatalog().Storage()
+		rp, err := repository.NewProductRepository(st)
+		require.NoError(t, err)
 		sv := service.NewProductService(rp)
 		hd := NewProductHandler(sv)
 		// Act
@@ -256,9 +744,11 @@ func TestUpdatePartial(t *testing.T) {
 		require.Equal(t, "application/json", res.Header().Get("Content-Type"))
 	})
 	t.Run("should throw a not found when id is not found", func(t *testing.T) {
+		t.Parallel()
 		// Arrange
-		st := storage.NewStorageJSON("../../docs/db/test_products.json")
-		rp := repository.NewProductRepository(st)
+		st := testCatalog().Storage()
+		rp, err := repository.NewProductRepository(st)
+		require.NoError(t, err)
 		sv := service.NewProductService(rp)
 		hd := NewProductHandler(sv)
 
@@ -270,6 +760,7 @@ func TestUpdatePartial(t *testing.T) {
 		chiCtx := chi.NewRouteContext()
 		chiCtx.URLParams.Add("id", "4")
 		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, chiCtx))
+		req.Header.Set("Content-Type", "application/json")
 
 		res := httptest.NewRecorder()
 		hdFunc := hd.UpdatePartial()
@@ -280,4 +771,31 @@ func TestUpdatePartial(t *testing.T) {
 		require.Equal(t, expectedBody, res.Body.String())
 		require.Equal(t, "application/json", res.Header().Get("Content-Type"))
 	})
+	t.Run("should throw a conflict when the code value is taken", func(t *testing.T) {
+		t.Parallel()
+		// Arrange
+		st := testCatalog().Storage()
+		rp, err := repository.NewProductRepository(st)
+		require.NoError(t, err)
+		sv := service.NewProductService(rp)
+		hd := NewProductHandler(sv)
+
+		body := `{"code_value": "T65812"}`
+
+		// Act
+		req := httptest.NewRequest("PATCH", "/products/1", strings.NewReader(body))
+		// Set query params with context
+		chiCtx := chi.NewRouteContext()
+		chiCtx.URLParams.Add("id", "1")
+		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, chiCtx))
+		req.Header.Set("Content-Type", "application/json")
+
+		res := httptest.NewRecorder()
+		hdFunc := hd.UpdatePartial()
+		hdFunc(res, req)
+		// Assert
+		expectedBody := `{"status_code":409,"message":"code value is already used by another product: \"T65812\"","data":null}`
+		require.Equal(t, 409, res.Code)
+		require.Equal(t, expectedBody, res.Body.String())
+	})
 }
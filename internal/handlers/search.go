@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	"net/url"
+	"slices"
+	"strings"
+	"unicode"
+	product "web/clase1/internal"
+	"web/clase1/platform/tools"
+)
+
+// searchParams are the query parameters accepted by a search by name
+var searchParams = []string{"cursor", "limit", "offset", "q"}
+
+// parseSearch reads the q parameter of a search by name and its page, by
+// offset or cursor like parsePage, of defaultLimit products unless limit
+// says otherwise. Results are ranked, so sort isn't accepted. Errors are a
+// *tools.FieldError naming the first invalid parameter in alphabetical
+// order, unknown parameters included.
+func parseSearch(query url.Values) (string, product.Page, error) {
+	params := make([]string, 0, len(query))
+	for param := range query {
+		params = append(params, param)
+	}
+	slices.Sort(params)
+	for _, param := range params {
+		if !slices.Contains(searchParams, param) {
+			return "", product.Page{}, &tools.FieldError{Field: param, Msg: "unknown query parameter"}
+		}
+		if len(query[param]) > 1 {
+			return "", product.Page{}, &tools.FieldError{Field: param, Msg: "must be given once"}
+		}
+	}
+
+	page, paginated, err := parsePage(query)
+	if err != nil {
+		return "", page, err
+	}
+	if !paginated {
+		page.Limit = defaultLimit
+	}
+
+	q := query.Get("q")
+	// names are searched by their words, punctuation alone matches nothing
+	if !strings.ContainsFunc(q, func(r rune) bool { return unicode.IsLetter(r) || unicode.IsDigit(r) }) {
+		return "", page, &tools.FieldError{Field: "q", Msg: "must have a word to search"}
+	}
+	return q, page, nil
+}
@@ -0,0 +1,50 @@
+package product
+
+// Page selects a window of a listing ordered by Sort, then by id. A
+// Cursor, when set, takes over Offset.
+type Page struct {
+	// Limit is the most products returned, zero returns them all
+	Limit  int
+	Offset int
+	Cursor *Cursor
+	Sort   []SortField
+}
+
+// Cursor continues a listing from the product Key, which is left out:
+// forward it returns the products after it, backward the ones before. Only
+// the id and the sorted fields of Key are used, so the listing continues
+// in place even if the product changed or was deleted.
+type Cursor struct {
+	Key      Product
+	Backward bool
+	// Inclusive keeps Key in the listing, to continue around a product that
+	// may still be there
+	Inclusive bool
+	// Score is the relevance of Key in a listing ranked by a search, which
+	// orders by it instead of Sort
+	Score float64
+}
+
+// Passes reports whether p, in a listing ordered by sort, is on the side of
+// the cursor the listing continues to
+func (c Cursor) Passes(p Product, sort []SortField) bool {
+	cmp := Compare(p, c.Key, sort)
+	if c.Backward {
+		cmp = -cmp
+	}
+	return cmp > 0 || c.Inclusive && cmp == 0
+}
+
+// ProductPage is the window of a listing selected by a Page
+type ProductPage struct {
+	Products []Product
+	// Total is the number of products in the whole listing
+	Total int
+	// HasNext and HasPrev report whether products follow or precede the
+	// window
+	HasNext bool
+	HasPrev bool
+	// Scores holds the relevance of each product in a listing ranked by a
+	// search, nil in the others
+	Scores []float64
+}
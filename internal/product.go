@@ -1,10 +1,19 @@
 package product
 
-import "errors"
+import (
+	"context"
+	"errors"
+)
 
 var (
 	ErrProdNotFound     = errors.New("product not found")
 	ErrProdInvalidField = errors.New("product is invalid")
+	// ErrProdConflict is returned when the products were changed elsewhere
+	// since they were loaded, the change was not applied
+	ErrProdConflict = errors.New("products were changed by another process")
+	// ErrProdDuplicateCode is returned when a change would give a product
+	// the code value of another one
+	ErrProdDuplicateCode = errors.New("code value is already used by another product")
 )
 
 type Product struct {
@@ -45,19 +54,34 @@ type RequestBodyProduct struct {
 type ProductRepository interface {
 	GetAllProducts() ([]Product, error)
 	GetProductById(id int) (*Product, error)
+	// GetProductByCode returns the product with the given code value, code
+	// values are unique
+	GetProductByCode(code string) (*Product, error)
 	CreateProduct(p *Product) error
 	FindProductsByPriceGt(price float64) []Product
+	// FindProducts returns the page of the products matched by filter,
+	// ordered by page.Sort, then by id
+	FindProducts(filter Filter, page Page) (ProductPage, error)
+	// SearchProducts returns the page of the products whose names match
+	// query, even partially or misspelled, the most relevant first and by
+	// id among equals. page.Sort doesn't apply.
+	SearchProducts(query string, page Page) (ProductPage, error)
 	UpdateOrCreateProduct(p *RequestBodyProduct, id int) error
 	UpdatePartial(map[string]any, int) error
 	DeleteProduct(id int) error
 }
 
+// ProductService mutations receive the request context so the caller
+// identity set by the auth middleware is available
 type ProductService interface {
 	GetAllProducts() ([]Product, error)
 	GetProductById(id int) (*Product, error)
-	CreateProduct(p *Product) (err error)
+	GetProductByCode(code string) (*Product, error)
+	CreateProduct(ctx context.Context, p *Product) (err error)
 	FindProductsByPriceGt(price float64) []Product
-	UpdateOrCreateProduct(p *RequestBodyProduct, id int) error
-	UpdatePartial(map[string]any, int) error
-	DeleteProduct(id int) error
+	FindProducts(filter Filter, page Page) (ProductPage, error)
+	SearchProducts(query string, page Page) (ProductPage, error)
+	UpdateOrCreateProduct(ctx context.Context, p *RequestBodyProduct, id int) error
+	UpdatePartial(ctx context.Context, fields map[string]any, id int) error
+	DeleteProduct(ctx context.Context, id int) error
 }
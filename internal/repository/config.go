@@ -0,0 +1,230 @@
+package repository
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"web/clase1/internal"
+	"web/clase1/internal/storage"
+	"web/clase1/platform/tools"
+)
+
+const (
+	BackendJSON   = "json"
+	BackendWAL    = "wal"
+	BackendSQLite = "sqlite"
+	BackendBolt   = "bolt"
+	BackendCSV    = "csv"
+)
+
+// Config selects the backend holding the products. Paths are resolved
+// relative to the directory of the config file.
+type Config struct {
+	Backend string `json:"backend"`
+	Path    string `json:"path"`
+	// Backups is the number of previous versions kept by the file backends
+	Backups int `json:"backups"`
+	// Compression of the json backend file: "none", "gzip" or "zstd".
+	// Empty picks it from the extension of Path (.gz, .zst). The other
+	// backends don't compress, and setting it for them is an error.
+	Compression string `json:"compression"`
+	// EncryptionKeyFile or EncryptionKeyEnv hold the base64 AES-256 keys
+	// encrypting the json backend file, newest first
+	EncryptionKeyFile string `json:"encryption_key_file"`
+	EncryptionKeyEnv  string `json:"encryption_key_env"`
+	// LogPath and CompactAfter configure the wal backend
+	LogPath      string `json:"log_path"`
+	CompactAfter int    `json:"compact_after"`
+	// CreateMissing starts the file backends with an empty catalog when
+	// Path doesn't exist, the file is created by the first change
+	CreateMissing bool `json:"create_missing"`
+	// WatchSeconds is how often the file backends check Path for changes
+	// made by other tools, zero disables it
+	WatchSeconds int `json:"watch_seconds"`
+}
+
+// LoadConfig reads a Config from a JSON file
+func LoadConfig(path string) (Config, error) {
+	var cfg Config
+	data, err := tools.ReadFile(path)
+	if err != nil {
+		return cfg, err
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("%s: %w", path, err)
+	}
+
+	dir := filepath.Dir(path)
+	for _, p := range []*string{&cfg.Path, &cfg.LogPath, &cfg.EncryptionKeyFile} {
+		if *p != "" && !filepath.IsAbs(*p) {
+			*p = filepath.Join(dir, *p)
+		}
+	}
+	return cfg, nil
+}
+
+// New opens the repository described by cfg
+func New(cfg Config) (product.ProductRepository, error) {
+	switch cfg.Backend {
+	case BackendSQLite:
+		return OpenProductSQLite(cfg.Path)
+	case BackendBolt:
+		return OpenProductBolt(cfg.Path)
+	}
+
+	st, err := fileStorage(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return newProductSlice(st, cfg)
+}
+
+// MigrateFile upgrades the products document of a file backend to
+// SchemaVersion, see Migrate
+func MigrateFile(cfg Config, dryRun bool) (MigrationReport, error) {
+	// columns are the schema of a CSV file, it has no version to upgrade
+	if cfg.Backend == BackendCSV {
+		return MigrationReport{}, errors.New("the csv backend has no schema version")
+	}
+	st, err := fileStorage(cfg)
+	if err != nil {
+		return MigrationReport{}, err
+	}
+	report, err := Migrate(st, dryRun)
+	if err != nil {
+		return report, fmt.Errorf("could not migrate %s: %w", cfg.Path, err)
+	}
+	return report, nil
+}
+
+// fileStorage returns the storage of the backends keeping the products in
+// a file
+func fileStorage(cfg Config) (storage.Storage, error) {
+	if cfg.Backend != BackendJSON && cfg.Backend != "" &&
+		cfg.Compression != "" && cfg.Compression != storage.CompressionNone {
+		return nil, fmt.Errorf("the %s backend doesn't support compression", cfg.Backend)
+	}
+
+	switch cfg.Backend {
+	case BackendJSON, "":
+		return jsonStorage(cfg, false)
+	case BackendWAL:
+		st := storage.NewStorageWAL(cfg.Path, cfg.LogPath, cfg.CompactAfter)
+		st.Snapshot.Backups = cfg.Backups
+		return st, nil
+	case BackendCSV:
+		return storage.NewStorageCSVFile(cfg.Path, cfg.Backups), nil
+	case BackendSQLite, BackendBolt:
+		return nil, fmt.Errorf("the %s backend doesn't keep the products in a file", cfg.Backend)
+	}
+	return nil, fmt.Errorf("unknown repository backend %q", cfg.Backend)
+}
+
+// jsonStorage compresses the file when the config or its extension asks
+// for it, and encrypts it when keys are configured. allowPlaintext reads an
+// unencrypted file as is, see EncryptFile.
+func jsonStorage(cfg Config, allowPlaintext bool) (storage.Storage, error) {
+	compression := cfg.Compression
+	if compression == "" {
+		compression = storage.CompressionForPath(cfg.Path)
+	}
+
+	keys, err := cfg.encryptionKeys()
+	if err != nil {
+		return nil, err
+	}
+	if keys == nil {
+		st := storage.NewStorageJSON(cfg.Path)
+		st.Backups = cfg.Backups
+		if compression == storage.CompressionNone {
+			return st, nil
+		}
+		return storage.NewStorageCompressed(st, compression), nil
+	}
+
+	// data that fails authentication must stop the startup, so backups
+	// aren't tried in its place
+	file := storage.NewStorageFile(cfg.Path)
+	file.Backups = cfg.Backups
+	encrypted, err := storage.NewStorageEncrypted(file, keys)
+	if err != nil {
+		return nil, err
+	}
+	encrypted.AllowPlaintext = allowPlaintext
+	if compression == storage.CompressionNone {
+		return encrypted, nil
+	}
+	// compress before encrypting, ciphertext doesn't compress
+	return storage.NewStorageCompressed(encrypted, compression), nil
+}
+
+// EncryptFile rewrites the plaintext file of the json backend encrypted
+// with the configured keys, and reports whether it was plaintext. An
+// encrypted file is left untouched. With dryRun the file is only decoded.
+// Backups written before keep their plaintext copies.
+func EncryptFile(cfg Config, dryRun bool) (bool, error) {
+	if cfg.Backend != BackendJSON && cfg.Backend != "" {
+		return false, fmt.Errorf("the %s backend doesn't support encryption", cfg.Backend)
+	}
+	keys, err := cfg.encryptionKeys()
+	if err != nil {
+		return false, err
+	}
+	if keys == nil {
+		return false, errors.New("encryption isn't configured")
+	}
+
+	st, err := jsonStorage(cfg, false)
+	if err != nil {
+		return false, err
+	}
+	_, err = st.Read()
+	if err == nil {
+		return false, nil
+	}
+	if !errors.Is(err, storage.ErrPlaintext) {
+		return false, err
+	}
+
+	if st, err = jsonStorage(cfg, true); err != nil {
+		return true, err
+	}
+	// load through the repository so a broken file isn't encrypted
+	r, err := loadProductSlice(st, false)
+	if err != nil {
+		return true, fmt.Errorf("could not load products from %s: %w", cfg.Path, err)
+	}
+	if dryRun {
+		return true, nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return true, r.save()
+}
+
+// encryptionKeys returns nil when encryption isn't configured
+func (cfg Config) encryptionKeys() ([][]byte, error) {
+	var keys [][]byte
+	var err error
+	switch {
+	case cfg.EncryptionKeyFile != "":
+		keys, err = storage.LoadEncryptionKeys(cfg.EncryptionKeyFile)
+	case cfg.EncryptionKeyEnv != "":
+		keys, err = storage.EncryptionKeysFromEnv(cfg.EncryptionKeyEnv)
+	default:
+		return nil, nil
+	}
+	if err == nil && len(keys) == 0 {
+		err = errors.New("encryption is configured but no key was found")
+	}
+	return keys, err
+}
+
+func newProductSlice(st storage.Storage, cfg Config) (product.ProductRepository, error) {
+	rp, err := loadProductSlice(st, cfg.CreateMissing)
+	if err != nil {
+		return nil, fmt.Errorf("could not load products from %s: %w", cfg.Path, err)
+	}
+	return rp, nil
+}
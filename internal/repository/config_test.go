@@ -0,0 +1,156 @@
+package repository
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+	"web/clase1/internal"
+	"web/clase1/internal/storage"
+
+	"github.com/stretchr/testify/require"
+)
+
+// writeEncryptionKey writes a new base64 key to a file in dir and returns
+// its path
+func writeEncryptionKey(t *testing.T, dir string) string {
+	t.Helper()
+
+	key := make([]byte, 32)
+	_, err := rand.Read(key)
+	require.NoError(t, err)
+	path := filepath.Join(dir, "products.key")
+	require.NoError(t, os.WriteFile(path, []byte(base64.StdEncoding.EncodeToString(key)), 0600))
+	return path
+}
+
+func TestNew(t *testing.T) {
+	t.Run("should encrypt and compress the json backend and refuse a tampered file", func(t *testing.T) {
+		// Arrange
+		dir := t.TempDir()
+		writeEncryptionKey(t, dir)
+		config := `{"backend":"json","path":"products.json.zst","encryption_key_file":"products.key"}`
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "repository.json"), []byte(config), 0644))
+
+		cfg, err := LoadConfig(filepath.Join(dir, "repository.json"))
+		require.NoError(t, err)
+		st, err := jsonStorage(cfg, false)
+		require.NoError(t, err)
+		require.NoError(t, st.Write([]byte(`[]`)))
+
+		// Act
+		rp, err := New(cfg)
+		require.NoError(t, err)
+		p := product.Product{Name: "Oil - Margarine", CodeValue: "S82254D"}
+		require.NoError(t, rp.CreateProduct(&p))
+		reopened, err := New(cfg)
+		require.NoError(t, err)
+
+		// Assert
+		found, err := reopened.GetProductById(p.Id)
+		require.NoError(t, err)
+		require.Equal(t, p, *found)
+
+		raw, err := os.ReadFile(cfg.Path)
+		require.NoError(t, err)
+		raw[len(raw)-1] ^= 1
+		require.NoError(t, os.WriteFile(cfg.Path, raw, 0644))
+		_, err = New(cfg)
+		require.Error(t, err)
+	})
+	t.Run("should start empty when the file is missing and asked to", func(t *testing.T) {
+		// Arrange
+		cfg := Config{Backend: BackendJSON, Path: filepath.Join(t.TempDir(), "products.json")}
+		_, err := New(cfg)
+		require.ErrorIs(t, err, os.ErrNotExist)
+		cfg.CreateMissing = true
+		// Act
+		rp, err := New(cfg)
+		require.NoError(t, err)
+		p := product.Product{Name: "Oil - Margarine"}
+		require.NoError(t, rp.CreateProduct(&p))
+		// Assert
+		require.Equal(t, 1, p.Id)
+		require.FileExists(t, cfg.Path)
+	})
+	t.Run("should report the path and line of a syntax error", func(t *testing.T) {
+		// Arrange
+		path := filepath.Join(t.TempDir(), "products.json")
+		require.NoError(t, os.WriteFile(path, []byte("[\n  {\"id\": 1},\n  {\"id\": 2,,}\n]"), 0644))
+		// Act
+		_, err := New(Config{Backend: BackendJSON, Path: path})
+		// Assert
+		var decodeErr *DecodeError
+		require.ErrorAs(t, err, &decodeErr)
+		require.Equal(t, 3, decodeErr.Line)
+		require.Equal(t, 12, decodeErr.Column)
+		require.ErrorContains(t, err, path)
+	})
+	t.Run("should refuse to compress the backends that can't", func(t *testing.T) {
+		for _, backend := range []string{BackendWAL, BackendCSV} {
+			// Arrange
+			dir := t.TempDir()
+			cfg := Config{
+				Backend:       backend,
+				Path:          filepath.Join(dir, "products"),
+				LogPath:       filepath.Join(dir, "products.wal"),
+				Compression:   "zstd",
+				CreateMissing: true,
+			}
+			// Act
+			_, err := New(cfg)
+			// Assert
+			require.ErrorContains(t, err, "doesn't support compression")
+		}
+	})
+}
+
+func TestEncryptFile(t *testing.T) {
+	t.Run("should encrypt a plaintext file once", func(t *testing.T) {
+		// Arrange
+		dir := t.TempDir()
+		cfg := Config{
+			Backend:           BackendJSON,
+			Path:              filepath.Join(dir, "products.json.gz"),
+			EncryptionKeyFile: writeEncryptionKey(t, dir),
+		}
+		plain := Config{Backend: BackendJSON, Path: cfg.Path, CreateMissing: true}
+		rp, err := New(plain)
+		require.NoError(t, err)
+		p := product.Product{Name: "Oil - Margarine", CodeValue: "S82254D"}
+		require.NoError(t, rp.CreateProduct(&p))
+		_, err = New(cfg)
+		require.ErrorIs(t, err, storage.ErrPlaintext)
+
+		// Act
+		dryRun, err := EncryptFile(cfg, true)
+		require.NoError(t, err)
+		_, err = New(cfg)
+		require.ErrorIs(t, err, storage.ErrPlaintext)
+		encrypted, err := EncryptFile(cfg, false)
+		require.NoError(t, err)
+		again, err := EncryptFile(cfg, false)
+		require.NoError(t, err)
+
+		// Assert
+		require.True(t, dryRun)
+		require.True(t, encrypted)
+		require.False(t, again)
+		reopened, err := New(cfg)
+		require.NoError(t, err)
+		found, err := reopened.GetProductById(p.Id)
+		require.NoError(t, err)
+		require.Equal(t, p, *found)
+		_, err = New(plain)
+		require.Error(t, err)
+	})
+	t.Run("should refuse when encryption isn't configured", func(t *testing.T) {
+		// Arrange
+		cfg := Config{Backend: BackendJSON, Path: filepath.Join(t.TempDir(), "products.json")}
+		// Act
+		_, err := EncryptFile(cfg, false)
+		// Assert
+		require.ErrorContains(t, err, "encryption isn't configured")
+	})
+}
@@ -0,0 +1,270 @@
+package repository
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"web/clase1/internal"
+	"web/clase1/internal/storage"
+)
+
+// DecodeError locates a problem in the products document, Line and Column
+// start at 1
+type DecodeError struct {
+	Offset int64
+	Line   int
+	Column int
+	Err    error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("line %d, column %d: %v", e.Line, e.Column, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// lineReader records where lines end so offsets into what it read can be
+// turned into a line and column
+type lineReader struct {
+	r        io.Reader
+	read     int64
+	newlines []int64
+}
+
+func (l *lineReader) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	for i := 0; i < n; {
+		j := bytes.IndexByte(p[i:n], '\n')
+		if j < 0 {
+			break
+		}
+		l.newlines = append(l.newlines, l.read+int64(i+j))
+		i += j + 1
+	}
+	l.read += int64(n)
+	return n, err
+}
+
+// position returns the 1-based line and column of offset
+func (l *lineReader) position(offset int64) (line, column int) {
+	// newlines before offset
+	i := sort.Search(len(l.newlines), func(i int) bool { return l.newlines[i] >= offset })
+	start := int64(0)
+	if i > 0 {
+		start = l.newlines[i-1] + 1
+	}
+	return i + 1, int(offset-start) + 1
+}
+
+// readProducts decodes the products document of st, streaming it when the
+// storage supports it. A stream that fails to decode is retried through
+// Read, which may recover the content from a backup.
+func readProducts(st storage.Storage) (productsDocument, error) {
+	var streamErr error
+	if streamer, ok := st.(storage.Streamer); ok {
+		stream, err := streamer.ReadStream()
+		if err == nil {
+			var doc productsDocument
+			doc, streamErr = decodeProducts(stream, SchemaMigrations)
+			stream.Close()
+			if streamErr == nil {
+				return doc, nil
+			}
+		}
+	}
+
+	data, err := st.Read()
+	if err != nil {
+		// the stream tells where the file is broken
+		if streamErr != nil {
+			err = fmt.Errorf("%w: %w", err, streamErr)
+		}
+		return productsDocument{}, err
+	}
+	return decodeProducts(bytes.NewReader(data), SchemaMigrations)
+}
+
+// readCurrentProducts decodes the products document of st without falling
+// back to its backups, see storage.ReadCurrent
+func readCurrentProducts(st storage.Storage) (productsDocument, error) {
+	data, err := storage.ReadCurrent(st)
+	if err != nil {
+		return productsDocument{}, err
+	}
+	return decodeProducts(bytes.NewReader(data), SchemaMigrations)
+}
+
+// decodeProducts reads a productsDocument, or a bare array of products,
+// one product at a time so the raw file is never held in memory. Products
+// of older schema versions are upgraded by m, SchemaVersion is left as
+// read. Errors are a *DecodeError holding the offset they were found at.
+func decodeProducts(r io.Reader, m Migrations) (productsDocument, error) {
+	lines := &lineReader{r: r}
+	dec := json.NewDecoder(lines)
+	doc, err := decodeDocument(dec, m)
+	if err != nil {
+		offset := errorOffset(err, dec)
+		line, column := lines.position(offset)
+		return doc, &DecodeError{Offset: offset, Line: line, Column: column, Err: err}
+	}
+	return doc, nil
+}
+
+// errorOffset prefers the offset of a syntax error, which is counted past
+// the offending byte. Other errors, type mismatches included, are located
+// just past the token or product being decoded.
+func errorOffset(err error, dec *json.Decoder) int64 {
+	var syntaxErr *json.SyntaxError
+	if errors.As(err, &syntaxErr) && syntaxErr.Offset > 0 {
+		return syntaxErr.Offset - 1
+	}
+	return dec.InputOffset()
+}
+
+func decodeDocument(dec *json.Decoder, m Migrations) (productsDocument, error) {
+	// documents are version 1 until they say otherwise
+	doc := productsDocument{SchemaVersion: 1}
+	pending := m
+
+	tok, err := dec.Token()
+	if err != nil {
+		return doc, err
+	}
+
+	switch tok {
+	case json.Delim('['):
+		if doc.Products, err = decodeProductArray(dec, pending); err != nil {
+			return doc, err
+		}
+	case json.Delim('{'):
+		// products read before the schema version, upgraded once it is known
+		var raw []json.RawMessage
+		versioned := false
+		for dec.More() {
+			key, err := dec.Token()
+			if err != nil {
+				return doc, err
+			}
+
+			switch key {
+			case "schema_version":
+				if err := dec.Decode(&doc.SchemaVersion); err != nil {
+					return doc, err
+				}
+				if pending, err = m.pending(doc.SchemaVersion); err != nil {
+					return doc, err
+				}
+				versioned = true
+			case "products":
+				tok, err := dec.Token()
+				if err != nil {
+					return doc, err
+				}
+				if tok == nil {
+					continue
+				}
+				if tok != json.Delim('[') {
+					return doc, fmt.Errorf("products: expected an array, got %v", tok)
+				}
+				if !versioned {
+					if raw, err = decodeRawArray(dec); err != nil {
+						return doc, err
+					}
+					continue
+				}
+				if doc.Products, err = decodeProductArray(dec, pending); err != nil {
+					return doc, err
+				}
+			case "last_id":
+				if err := dec.Decode(&doc.LastId); err != nil {
+					return doc, err
+				}
+			default:
+				// skip fields this version doesn't know
+				var skip json.RawMessage
+				if err := dec.Decode(&skip); err != nil {
+					return doc, err
+				}
+			}
+		}
+		if _, err := dec.Token(); err != nil {
+			return doc, err
+		}
+
+		if raw != nil {
+			doc.Products = make([]product.Product, 0, len(raw))
+			for _, data := range raw {
+				p, err := pending.upgradeProduct(data)
+				if err != nil {
+					return doc, err
+				}
+				doc.Products = append(doc.Products, p)
+			}
+		}
+	case nil:
+		// a null document is an empty catalog
+	default:
+		return doc, fmt.Errorf("expected a products document, got %v", tok)
+	}
+
+	// nothing but whitespace may follow the document
+	if _, err := dec.Token(); err != io.EOF {
+		return doc, fmt.Errorf("unexpected data after the products document")
+	}
+
+	for _, p := range doc.Products {
+		if p.Id > doc.LastId {
+			doc.LastId = p.Id
+		}
+	}
+	return doc, nil
+}
+
+// decodeProductArray decodes the elements of an array whose opening
+// bracket was already read, and its closing bracket, upgrading them with
+// the pending migrations
+func decodeProductArray(dec *json.Decoder, pending Migrations) ([]product.Product, error) {
+	products := []product.Product{}
+	for dec.More() {
+		var p product.Product
+		if pending.changeProducts() {
+			var raw json.RawMessage
+			if err := dec.Decode(&raw); err != nil {
+				return nil, err
+			}
+			var err error
+			if p, err = pending.upgradeProduct(raw); err != nil {
+				return nil, err
+			}
+		} else if err := dec.Decode(&p); err != nil {
+			return nil, err
+		}
+		products = append(products, p)
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, err
+	}
+	return products, nil
+}
+
+// decodeRawArray is decodeProductArray for products whose schema version
+// isn't known yet
+func decodeRawArray(dec *json.Decoder) ([]json.RawMessage, error) {
+	products := []json.RawMessage{}
+	for dec.More() {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return nil, err
+		}
+		products = append(products, raw)
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, err
+	}
+	return products, nil
+}
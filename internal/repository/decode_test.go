@@ -0,0 +1,54 @@
+package repository
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"web/clase1/internal"
+	"web/clase1/internal/storage"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeProducts(t *testing.T) {
+	t.Run("should decode a document read a byte at a time", func(t *testing.T) {
+		// Arrange
+		r := iotest.OneByteReader(strings.NewReader(`{"last_id":7,"extra":{"a":[1]},"products":[{"id":1,"name":"Oil"},{"id":2,"name":"Wine"}]}`))
+		// Act
+		doc, err := decodeProducts(r, SchemaMigrations)
+		// Assert
+		require.NoError(t, err)
+		require.Equal(t, 7, doc.LastId)
+		require.Equal(t, []product.Product{{Id: 1, Name: "Oil"}, {Id: 2, Name: "Wine"}}, doc.Products)
+	})
+	t.Run("should decode a bare array taking the highest id as sequence", func(t *testing.T) {
+		// Act
+		doc, err := decodeProducts(iotest.HalfReader(strings.NewReader(`[{"id":4},{"id":9}]`)), SchemaMigrations)
+		// Assert
+		require.NoError(t, err)
+		require.Equal(t, 9, doc.LastId)
+		require.Len(t, doc.Products, 2)
+	})
+	t.Run("should fail on truncated or trailing data", func(t *testing.T) {
+		for _, data := range []string{`[{"id":1},`, `{"products":[{"id":1}]`, `[] []`, `"products"`} {
+			_, err := decodeProducts(strings.NewReader(data), SchemaMigrations)
+			require.Error(t, err, data)
+		}
+	})
+	t.Run("should fall back to a backup when the stream doesn't decode", func(t *testing.T) {
+		// Arrange
+		path := filepath.Join(t.TempDir(), "products.json")
+		st := storage.NewStorageJSON(path)
+		st.Backups = 1
+		require.NoError(t, st.Write([]byte(`[{"id":1}]`)))
+		require.NoError(t, st.Write([]byte(`[{"id":1},{"id":2}]`)))
+		require.NoError(t, os.WriteFile(path, []byte(`[{"id":1},{"i`), 0644))
+		// Act
+		doc, err := readProducts(st)
+		// Assert
+		require.NoError(t, err)
+		require.Equal(t, []product.Product{{Id: 1}}, doc.Products)
+	})
+}
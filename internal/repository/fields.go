@@ -0,0 +1,47 @@
+package repository
+
+import (
+	"fmt"
+	"web/clase1/internal"
+)
+
+// applyFields sets the fields of a partial update on p. Values come from
+// decoded JSON, so numbers arrive as float64.
+func applyFields(p *product.Product, fields map[string]any) error {
+	for key, value := range fields {
+		var ok bool
+		switch key {
+		case "name":
+			p.Name, ok = value.(string)
+		case "quantity":
+			var quantity float64
+			quantity, ok = toFloat(value)
+			ok = ok && quantity == float64(int(quantity))
+			p.Quantity = int(quantity)
+		case "code_value":
+			p.CodeValue, ok = value.(string)
+		case "is_published":
+			p.Is_Published, ok = value.(bool)
+		case "expiration":
+			p.Expiration, ok = value.(string)
+		case "price":
+			p.Price, ok = toFloat(value)
+		default:
+			return fmt.Errorf("%w: unknown field %s", product.ErrProdInvalidField, key)
+		}
+		if !ok {
+			return fmt.Errorf("%w: invalid value for %s", product.ErrProdInvalidField, key)
+		}
+	}
+	return nil
+}
+
+func toFloat(value any) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case int:
+		return float64(v), true
+	}
+	return 0, false
+}
@@ -0,0 +1,147 @@
+package repository
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"web/clase1/internal"
+	"web/clase1/internal/storage"
+)
+
+// Migration upgrades a stored document by one schema version
+type Migration struct {
+	Description string
+	// Product rewrites the fields of one stored product, nil when only the
+	// layout of the document changed
+	Product func(fields map[string]json.RawMessage) error
+}
+
+// Migrations is a registry holding one migration per schema version, the
+// one at index i upgrades documents of version i+1. Bare arrays and
+// documents without a schema_version are version 1.
+type Migrations []Migration
+
+// SchemaMigrations upgrades the documents written by older versions. New
+// migrations are appended, never edited once released.
+var SchemaMigrations = Migrations{
+	{Description: "store the products in a document with a schema version"},
+}
+
+// SchemaVersion is the version of the documents written by save
+var SchemaVersion = SchemaMigrations.Current()
+
+// Current is the version documents are upgraded to
+func (m Migrations) Current() int {
+	return len(m) + 1
+}
+
+// pending returns the migrations upgrading a document of version to Current
+func (m Migrations) pending(version int) (Migrations, error) {
+	if version < 1 || version > m.Current() {
+		return nil, fmt.Errorf("unsupported schema version %d, expected 1 to %d", version, m.Current())
+	}
+	return m[version-1:], nil
+}
+
+// upgradeProduct decodes raw, a product stored with the pending migrations
+// still to run
+func (m Migrations) upgradeProduct(raw json.RawMessage) (product.Product, error) {
+	var p product.Product
+	if !m.changeProducts() {
+		err := json.Unmarshal(raw, &p)
+		return p, err
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return p, err
+	}
+	for _, migration := range m {
+		if migration.Product == nil {
+			continue
+		}
+		if err := migration.Product(fields); err != nil {
+			return p, fmt.Errorf("%s: %w", migration.Description, err)
+		}
+	}
+
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return p, err
+	}
+	err = json.Unmarshal(data, &p)
+	return p, err
+}
+
+// changeProducts reports whether any migration rewrites products, when none
+// does they are decoded straight from the stream
+func (m Migrations) changeProducts() bool {
+	for _, migration := range m {
+		if migration.Product != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// MigrationReport describes the upgrade of a stored document
+type MigrationReport struct {
+	From     int
+	To       int
+	Products int
+	Applied  []string
+	DryRun   bool
+}
+
+func (r MigrationReport) String() string {
+	if r.From == r.To {
+		return fmt.Sprintf("schema version %d is current, %d products", r.From, r.Products)
+	}
+
+	var b strings.Builder
+	verb := "upgraded"
+	if r.DryRun {
+		verb = "would upgrade"
+	}
+	fmt.Fprintf(&b, "%s %d products from schema version %d to %d", verb, r.Products, r.From, r.To)
+	for i, description := range r.Applied {
+		fmt.Fprintf(&b, "\n  %d -> %d: %s", r.From+i, r.From+i+1, description)
+	}
+	return b.String()
+}
+
+// Migrate upgrades the document in st to SchemaVersion. With dryRun the
+// document is decoded and upgraded in memory only, st is left untouched.
+func Migrate(st storage.Storage, dryRun bool) (MigrationReport, error) {
+	doc, err := readProducts(st)
+	if err != nil {
+		return MigrationReport{}, err
+	}
+
+	report := MigrationReport{
+		From:     doc.SchemaVersion,
+		To:       SchemaVersion,
+		Products: len(doc.Products),
+		DryRun:   dryRun,
+	}
+	pending, err := SchemaMigrations.pending(doc.SchemaVersion)
+	if err != nil {
+		return report, err
+	}
+	for _, migration := range pending {
+		report.Applied = append(report.Applied, migration.Description)
+	}
+	if dryRun || len(pending) == 0 {
+		return report, nil
+	}
+
+	// load through the repository so journals are replayed before the
+	// snapshot replacing them is written
+	r, err := loadProductSlice(st, false)
+	if err != nil {
+		return report, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return report, r.save()
+}
@@ -0,0 +1,116 @@
+package repository
+
+import (
+	"encoding/json"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+	"web/clase1/internal"
+	"web/clase1/internal/repository/repositorytest"
+	"web/clase1/internal/storage"
+
+	"github.com/stretchr/testify/require"
+)
+
+// exampleMigrations extends SchemaMigrations the way later versions would
+var exampleMigrations = append(slices.Clone(SchemaMigrations),
+	Migration{
+		Description: "rename published to is_published",
+		Product: func(fields map[string]json.RawMessage) error {
+			if v, ok := fields["published"]; ok {
+				fields["is_published"] = v
+				delete(fields, "published")
+			}
+			return nil
+		},
+	},
+	Migration{
+		Description: "store expiration as an ISO date",
+		Product: func(fields map[string]json.RawMessage) error {
+			var expiration string
+			if err := json.Unmarshal(fields["expiration"], &expiration); err != nil {
+				return err
+			}
+			date, err := time.Parse(product.ExpirationLayout, expiration)
+			if err != nil {
+				return err
+			}
+			fields["expiration"], err = json.Marshal(date.Format(time.DateOnly))
+			return err
+		},
+	},
+)
+
+func TestMigrations(t *testing.T) {
+	t.Run("should upgrade the products of a bare array", func(t *testing.T) {
+		// Arrange
+		r := strings.NewReader(`[{"id":1,"published":true,"expiration":"15/12/2021"}]`)
+		// Act
+		doc, err := decodeProducts(r, exampleMigrations)
+		// Assert
+		require.NoError(t, err)
+		require.Equal(t, 1, doc.SchemaVersion)
+		require.Equal(t, []product.Product{{Id: 1, Is_Published: true, Expiration: "2021-12-15"}}, doc.Products)
+	})
+	t.Run("should upgrade products read before the schema version", func(t *testing.T) {
+		// Arrange
+		r := strings.NewReader(`{"products":[{"id":1,"published":true,"expiration":"15/12/2021"}],"schema_version":2}`)
+		// Act
+		doc, err := decodeProducts(r, exampleMigrations)
+		// Assert
+		require.NoError(t, err)
+		require.Equal(t, []product.Product{{Id: 1, Is_Published: true, Expiration: "2021-12-15"}}, doc.Products)
+	})
+	t.Run("should leave current documents as they are", func(t *testing.T) {
+		// Arrange
+		r := strings.NewReader(`{"schema_version":4,"products":[{"id":1,"is_published":true,"expiration":"2021-12-15"}]}`)
+		// Act
+		doc, err := decodeProducts(r, exampleMigrations)
+		// Assert
+		require.NoError(t, err)
+		require.Equal(t, []product.Product{{Id: 1, Is_Published: true, Expiration: "2021-12-15"}}, doc.Products)
+	})
+	t.Run("should refuse documents newer than the registry", func(t *testing.T) {
+		// Act
+		_, err := decodeProducts(strings.NewReader(`{"schema_version":5,"products":[]}`), exampleMigrations)
+		// Assert
+		require.ErrorContains(t, err, "unsupported schema version 5")
+	})
+}
+
+func TestMigrate(t *testing.T) {
+	t.Run("should only report the upgrade on a dry run", func(t *testing.T) {
+		// Arrange
+		path := seedProductsFile(t, repositorytest.Products(3))
+		before, err := os.ReadFile(path)
+		require.NoError(t, err)
+		// Act
+		report, err := Migrate(storage.NewStorageJSON(path), true)
+		// Assert
+		require.NoError(t, err)
+		require.Equal(t, MigrationReport{From: 1, To: SchemaVersion, Products: 3, Applied: []string{SchemaMigrations[0].Description}, DryRun: true}, report)
+		after, err := os.ReadFile(path)
+		require.NoError(t, err)
+		require.Equal(t, before, after)
+	})
+	t.Run("should write the upgraded document once", func(t *testing.T) {
+		// Arrange
+		path := seedProductsFile(t, repositorytest.Products(3))
+		// Act
+		_, err := Migrate(storage.NewStorageJSON(path), false)
+		require.NoError(t, err)
+		report, err := Migrate(storage.NewStorageJSON(path), false)
+		// Assert
+		require.NoError(t, err)
+		require.Equal(t, SchemaVersion, report.From)
+		require.Empty(t, report.Applied)
+		var doc productsDocument
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		require.NoError(t, json.Unmarshal(data, &doc))
+		require.Equal(t, SchemaVersion, doc.SchemaVersion)
+		require.Equal(t, repositorytest.Products(3), doc.Products)
+	})
+}
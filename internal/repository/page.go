@@ -0,0 +1,105 @@
+package repository
+
+import (
+	"slices"
+	"web/clase1/internal"
+)
+
+// findPage pages the products scan passes to add in id order, sorting them
+// first when the page asks for another order. Only sorted pages hold every
+// product found.
+func findPage(page product.Page, scan func(add func(product.Product)) error) (product.ProductPage, error) {
+	pg := newPager(page)
+	if len(page.Sort) == 0 {
+		if err := scan(pg.add); err != nil {
+			return product.ProductPage{}, err
+		}
+		return pg.result(), nil
+	}
+
+	var products []product.Product
+	err := scan(func(p product.Product) {
+		products = append(products, p)
+	})
+	if err != nil {
+		return product.ProductPage{}, err
+	}
+	slices.SortFunc(products, func(a, b product.Product) int {
+		return product.Compare(a, b, page.Sort)
+	})
+	for _, p := range products {
+		pg.add(p)
+	}
+	return pg.result(), nil
+}
+
+// pager collects a page while products are scanned in the order of the
+// listing, holding no more than the products of the page
+type pager struct {
+	page     product.Page
+	products []product.Product
+	total    int
+	// before counts the products up to the cursor, in cursor pages
+	before int
+}
+
+func newPager(page product.Page) *pager {
+	return &pager{page: page, products: []product.Product{}}
+}
+
+// add takes the next product of the listing
+func (pg *pager) add(p product.Product) {
+	pg.total++
+	limit := pg.page.Limit
+
+	cursor := pg.page.Cursor
+	switch {
+	case cursor == nil:
+		i := pg.total - 1
+		if i >= pg.page.Offset && (limit == 0 || i < pg.page.Offset+limit) {
+			pg.products = append(pg.products, p)
+		}
+	case !cursor.Backward:
+		if !cursor.Passes(p, pg.page.Sort) {
+			pg.before++
+			return
+		}
+		if limit == 0 || len(pg.products) < limit {
+			pg.products = append(pg.products, p)
+		}
+	default:
+		if !cursor.Passes(p, pg.page.Sort) {
+			return
+		}
+		pg.before++
+		// keep the last limit products before the cursor
+		pg.products = append(pg.products, p)
+		if limit > 0 && len(pg.products) > limit {
+			pg.products = pg.products[1:]
+		}
+	}
+}
+
+// result is the page once every product was added
+func (pg *pager) result() product.ProductPage {
+	return pageOf(pg.page, pg.products, pg.total, pg.before)
+}
+
+// pageOf tells whether products surround a window of a listing of total
+// products. before is the number of products up to a forward cursor, or
+// before a backward one.
+func pageOf(page product.Page, products []product.Product, total, before int) product.ProductPage {
+	result := product.ProductPage{Products: products, Total: total}
+	switch {
+	case page.Cursor == nil:
+		result.HasPrev = page.Offset > 0 && total > 0
+		result.HasNext = page.Offset+len(products) < total
+	case !page.Cursor.Backward:
+		result.HasPrev = before > 0
+		result.HasNext = total-before > len(products)
+	default:
+		result.HasPrev = before > len(products)
+		result.HasNext = total-before > 0
+	}
+	return result
+}
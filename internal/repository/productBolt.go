@@ -0,0 +1,382 @@
+package repository
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+	"web/clase1/internal"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	productsBucket = []byte("products")
+	// termsBucket is the inverted index of the product names, its keys are
+	// a term, a zero byte and the id of a product named with it
+	termsBucket = []byte("terms")
+	// codesBucket indexes the code values the same way. Puts keep them
+	// unique, only files written before the index hold more than one id.
+	codesBucket = []byte("codes")
+)
+
+// ProductBolt is a ProductRepository storing each product under its own key
+// in a bbolt file, so a change only rewrites the pages of that product.
+// Keys are big endian ids, which keeps cursors in id order, and ids come
+// from the bucket sequence so they are never reused.
+type ProductBolt struct {
+	db *bolt.DB
+}
+
+// OpenProductBolt opens the bolt file at path, creating it if needed
+func OpenProductBolt(path string) (*ProductBolt, error) {
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(productsBucket)
+		if err != nil {
+			return err
+		}
+		newTerms, newCodes := tx.Bucket(termsBucket) == nil, tx.Bucket(codesBucket) == nil
+		if !newTerms && !newCodes {
+			return nil
+		}
+		terms, err := tx.CreateBucketIfNotExists(termsBucket)
+		if err != nil {
+			return err
+		}
+		codes, err := tx.CreateBucketIfNotExists(codesBucket)
+		if err != nil {
+			return err
+		}
+		// index the products stored before the index buckets
+		return b.ForEach(func(_, v []byte) error {
+			var p product.Product
+			if err := json.Unmarshal(v, &p); err != nil {
+				return err
+			}
+			if newTerms {
+				if err := indexNameBolt(terms, p.Id, p.Name, true); err != nil {
+					return err
+				}
+			}
+			if newCodes {
+				return codes.Put(indexKey(p.CodeValue, p.Id), []byte{})
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &ProductBolt{
+		db: db,
+	}, nil
+}
+
+func (r *ProductBolt) Close() error {
+	return r.db.Close()
+}
+
+func (r *ProductBolt) GetAllProducts() ([]product.Product, error) {
+	var products []product.Product
+	err := r.each(func(p product.Product) {
+		products = append(products, p)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(products) == 0 {
+		return nil, errors.New("no products found")
+	}
+	return products, nil
+}
+
+func (r *ProductBolt) GetProductById(id int) (*product.Product, error) {
+	var p *product.Product
+	err := r.db.View(func(tx *bolt.Tx) (err error) {
+		p, err = getProductBolt(tx.Bucket(productsBucket), id)
+		return err
+	})
+	return p, err
+}
+
+func (r *ProductBolt) FindProductsByPriceGt(price float64) []product.Product {
+	var products []product.Product
+	r.each(func(p product.Product) {
+		if p.Price > price {
+			products = append(products, p)
+		}
+	})
+	return products
+}
+
+// FindProducts scans the bucket, whose big-endian keys keep it in id order
+func (r *ProductBolt) FindProducts(filter product.Filter, page product.Page) (product.ProductPage, error) {
+	return findPage(page, func(add func(product.Product)) error {
+		return r.each(func(p product.Product) {
+			if filter.Match(p) {
+				add(p)
+			}
+		})
+	})
+}
+
+func (r *ProductBolt) GetProductByCode(code string) (*product.Product, error) {
+	var p *product.Product
+	err := r.db.View(func(tx *bolt.Tx) (err error) {
+		id, ok := codeOwnerBolt(tx.Bucket(codesBucket), code)
+		if !ok {
+			return product.ErrProdNotFound
+		}
+		p, err = getProductBolt(tx.Bucket(productsBucket), id)
+		return err
+	})
+	return p, err
+}
+
+// SearchProducts ranks the names indexed in the terms bucket, in a single
+// read transaction so the products found are the ones indexed
+func (r *ProductBolt) SearchProducts(query string, page product.Page) (product.ProductPage, error) {
+	var result product.ProductPage
+	err := r.db.View(func(tx *bolt.Tx) error {
+		scan := func(from, to string, fn func(term string, ids []int)) error {
+			return scanTermsBolt(tx.Bucket(termsBucket), from, to, fn)
+		}
+		var err error
+		result, err = searchPage(query, page, scan, func(id int) (product.Product, error) {
+			p, err := getProductBolt(tx.Bucket(productsBucket), id)
+			if err != nil {
+				return product.Product{}, err
+			}
+			return *p, nil
+		})
+		return err
+	})
+	return result, err
+}
+
+// scanTermsBolt reads the terms of the terms bucket from from up to to, see
+// scanTerms, seeking them as its keys sort by term, calling fn once per term
+func scanTermsBolt(b *bolt.Bucket, from, to string, fn func(term string, ids []int)) error {
+	var current string
+	var ids []int
+	c := b.Cursor()
+	for k, _ := c.Seek([]byte(from)); k != nil; k, _ = c.Next() {
+		term, id := string(k[:len(k)-9]), int(binary.BigEndian.Uint64(k[len(k)-8:]))
+		if to != "" && term >= to {
+			break
+		}
+		if term != current && len(ids) > 0 {
+			fn(current, ids)
+			ids = nil
+		}
+		current = term
+		ids = append(ids, id)
+	}
+	if len(ids) > 0 {
+		fn(current, ids)
+	}
+	return nil
+}
+
+// indexKey is the key of an index bucket holding id under value
+func indexKey(value string, id int) []byte {
+	return append(append([]byte(value), 0), boltKey(id)...)
+}
+
+// codeOwnerBolt returns the lowest id indexed under code in the codes
+// bucket b
+func codeOwnerBolt(b *bolt.Bucket, code string) (int, bool) {
+	prefix := append([]byte(code), 0)
+	c := b.Cursor()
+	for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+		// longer keys belong to codes holding a zero byte
+		if len(k) == len(prefix)+8 {
+			return int(binary.BigEndian.Uint64(k[len(prefix):])), true
+		}
+	}
+	return 0, false
+}
+
+// indexNameBolt adds the terms of the name of the product with the given
+// id to the terms bucket b, or deletes them when add is false
+func indexNameBolt(b *bolt.Bucket, id int, name string, add bool) error {
+	for _, term := range searchTerms(name) {
+		var err error
+		if add {
+			err = b.Put(indexKey(term, id), []byte{})
+		} else {
+			err = b.Delete(indexKey(term, id))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unindexProductBolt deletes the terms and the code of the stored product
+// with the given id, if there is one
+func unindexProductBolt(b *bolt.Bucket, id int) error {
+	old, err := getProductBolt(b, id)
+	if errors.Is(err, product.ErrProdNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := b.Tx().Bucket(codesBucket).Delete(indexKey(old.CodeValue, id)); err != nil {
+		return err
+	}
+	return indexNameBolt(b.Tx().Bucket(termsBucket), id, old.Name, false)
+}
+
+func (r *ProductBolt) CreateProduct(p *product.Product) error {
+	return r.db.Update(func(tx *bolt.Tx) error {
+		return createProductBolt(tx.Bucket(productsBucket), p)
+	})
+}
+
+func (r *ProductBolt) UpdateOrCreateProduct(p *product.RequestBodyProduct, id int) error {
+	return r.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(productsBucket)
+
+		// like ProductSlice, missing products are created with a new id
+		existing, err := getProductBolt(b, id)
+		if errors.Is(err, product.ErrProdNotFound) {
+			existing, err = &product.Product{}, nil
+		}
+		if err != nil {
+			return err
+		}
+
+		existing.Name = p.Name
+		existing.Quantity = p.Quantity
+		existing.CodeValue = p.CodeValue
+		existing.Is_Published = p.Is_Published
+		existing.Expiration = p.Expiration
+		existing.Price = p.Price
+		if existing.Id == 0 {
+			return createProductBolt(b, existing)
+		}
+		return putProductBolt(b, *existing)
+	})
+}
+
+func (r *ProductBolt) UpdatePartial(fields map[string]any, id int) error {
+	return r.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(productsBucket)
+
+		p, err := getProductBolt(b, id)
+		if err != nil {
+			return err
+		}
+		if err := applyFields(p, fields); err != nil {
+			return err
+		}
+		return putProductBolt(b, *p)
+	})
+}
+
+func (r *ProductBolt) DeleteProduct(id int) error {
+	return r.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(productsBucket)
+		if b.Get(boltKey(id)) == nil {
+			return product.ErrProdNotFound
+		}
+		if err := unindexProductBolt(b, id); err != nil {
+			return err
+		}
+		return b.Delete(boltKey(id))
+	})
+}
+
+// each decodes the products one at a time, in id order
+func (r *ProductBolt) each(fn func(product.Product)) error {
+	return r.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(productsBucket).ForEach(func(_, v []byte) error {
+			var p product.Product
+			if err := json.Unmarshal(v, &p); err != nil {
+				return err
+			}
+			fn(p)
+			return nil
+		})
+	})
+}
+
+func boltKey(id int) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(id))
+	return key
+}
+
+func getProductBolt(b *bolt.Bucket, id int) (*product.Product, error) {
+	v := b.Get(boltKey(id))
+	if v == nil {
+		return nil, product.ErrProdNotFound
+	}
+	var p product.Product
+	if err := json.Unmarshal(v, &p); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func createProductBolt(b *bolt.Bucket, p *product.Product) error {
+	seq, err := b.NextSequence()
+	if err != nil {
+		return err
+	}
+	// p keeps its id unless it is stored
+	created := *p
+	created.Id = int(seq)
+	if err := putProductBolt(b, created); err != nil {
+		return err
+	}
+	p.Id = created.Id
+	return nil
+}
+
+// putProductBolt stores p under its id, moving the sequence past it so
+// seeded or imported ids are never handed out again, and indexes its name
+// and code in place of the stored ones. It returns ErrProdDuplicateCode
+// when another product uses the code, the transaction must then be rolled
+// back.
+func putProductBolt(b *bolt.Bucket, p product.Product) error {
+	if uint64(p.Id) > b.Sequence() {
+		if err := b.SetSequence(uint64(p.Id)); err != nil {
+			return err
+		}
+	}
+	// a product keeping its code is let through, it may share it with
+	// others stored before codes were unique
+	old, err := getProductBolt(b, p.Id)
+	keepsCode := err == nil && old.CodeValue == p.CodeValue
+	if err := unindexProductBolt(b, p.Id); err != nil {
+		return err
+	}
+	codes := b.Tx().Bucket(codesBucket)
+	if _, ok := codeOwnerBolt(codes, p.CodeValue); ok && !keepsCode {
+		return fmt.Errorf("%w: %q", product.ErrProdDuplicateCode, p.CodeValue)
+	}
+	if err := codes.Put(indexKey(p.CodeValue, p.Id), []byte{}); err != nil {
+		return err
+	}
+	data, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	if err := b.Put(boltKey(p.Id), data); err != nil {
+		return err
+	}
+	return indexNameBolt(b.Tx().Bucket(termsBucket), p.Id, p.Name, true)
+}
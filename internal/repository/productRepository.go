@@ -1,54 +1,172 @@
 package repository
 
 import (
+	"cmp"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io/fs"
+	"slices"
+	"sync"
 	"web/clase1/internal"
 	"web/clase1/internal/storage"
 )
 
+// ProductSlice is safe for concurrent use. Reads share mu, mutations hold it
+// exclusively until the slice has been written to storage.
 type ProductSlice struct {
+	mu sync.RWMutex
+	// slice is kept in id order, new ids are always the highest
 	slice   []product.Product
 	storage storage.Storage
+	// lastId is the last id handed out, ids are never reused
+	lastId int
+	// index maps a product id to its position in slice
+	index map[int]int
+	// names indexes the terms of the product names for SearchProducts
+	names *nameIndex
+	// codes maps a code value to the ids of the products using it in order.
+	// Mutations keep it unique, only files edited by hand list more.
+	codes map[string][]int
 }
 
-func NewProductRepository(st storage.Storage) *ProductSlice {
-	data, err := st.Read()
-	if err != nil {
-		return nil
-	}
+const (
+	opCreate = "create"
+	opUpdate = "update"
+	opDelete = "delete"
+)
 
+// productsEntry is a single change recorded by a storage.Journal
+type productsEntry struct {
+	Op      string          `json:"op"`
+	Product product.Product `json:"product"`
+}
+
+// productsDocument is the layout of the storage file. Files holding a bare
+// array of products are still read, taking the highest id as the sequence,
+// and upgraded by SchemaMigrations like older documents.
+type productsDocument struct {
+	SchemaVersion int               `json:"schema_version"`
+	LastId        int               `json:"last_id"`
+	Products      []product.Product `json:"products"`
+}
+
+// NewProductRepository loads the products in st. Decoding errors are a
+// *DecodeError locating the problem in the document.
+func NewProductRepository(st storage.Storage) (*ProductSlice, error) {
+	return loadProductSlice(st, false)
+}
+
+// loadProductSlice reads the products in st and replays its journal. With
+// missingOK a storage whose file doesn't exist yet holds no products.
+func loadProductSlice(st storage.Storage, missingOK bool) (*ProductSlice, error) {
 	//convert data to slice of products
-	var products []product.Product
-	err = json.Unmarshal(data, &products)
+	doc, err := readProducts(st)
+	if missingOK && errors.Is(err, fs.ErrNotExist) {
+		doc, err = productsDocument{Products: []product.Product{}}, nil
+	}
 	if err != nil {
-		return nil
+		return nil, err
+	}
+	return buildProductSlice(st, doc)
+}
+
+// buildProductSlice indexes the products of doc and replays the journal of st
+func buildProductSlice(st storage.Storage, doc productsDocument) (*ProductSlice, error) {
+	// files edited by hand may list the products in any order
+	slices.SortStableFunc(doc.Products, func(a, b product.Product) int {
+		return cmp.Compare(a.Id, b.Id)
+	})
+	index := make(map[int]int, len(doc.Products))
+	names := newNameIndex()
+	codes := make(map[string][]int, len(doc.Products))
+	for i, p := range doc.Products {
+		// ids must be unique for the index to be usable
+		if _, ok := index[p.Id]; ok {
+			return nil, fmt.Errorf("duplicated product id %d", p.Id)
+		}
+		index[p.Id] = i
+		names.put(p.Id, p.Name)
+		codes[p.CodeValue] = append(codes[p.CodeValue], p.Id)
 	}
 
-	return &ProductSlice{
-		slice:   products,
+	r := &ProductSlice{
+		slice:   doc.Products,
 		storage: st,
+		lastId:  doc.LastId,
+		index:   index,
+		names:   names,
+		codes:   codes,
 	}
+
+	// replay the changes recorded after the snapshot
+	if journal, ok := st.(storage.Journal); ok {
+		if err := journal.Replay(r.apply); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// Reload rereads the storage and swaps in its products when they are valid
+// and differ from the ones served, reporting whether they did. On error the
+// current products are kept: backups aren't read in place of a broken file,
+// they would roll back the changes made since.
+func (r *ProductSlice) Reload() (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.reload()
+}
+
+// reload expects mu to be held by the caller. Reading under the lock keeps
+// the content the storage last saw in step with the products served.
+func (r *ProductSlice) reload() (bool, error) {
+	doc, err := readCurrentProducts(r.storage)
+	if err != nil {
+		return false, err
+	}
+	loaded, err := buildProductSlice(r.storage, doc)
+	if err != nil {
+		return false, err
+	}
+	// ids handed out before the reload are never reused, nor those handed
+	// out by another process even if the products it created are gone
+	r.lastId = max(r.lastId, loaded.lastId)
+	if slices.Equal(r.slice, loaded.slice) {
+		return false, nil
+	}
+	r.slice = loaded.slice
+	r.index = loaded.index
+	r.names = loaded.names
+	r.codes = loaded.codes
+	return true, nil
 }
 
 func (r *ProductSlice) GetAllProducts() ([]product.Product, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
 	if len(r.slice) == 0 {
 		return nil, errors.New("no products found")
 	}
-	return r.slice, nil
+	// copy so callers never share the backing array with later mutations
+	products := make([]product.Product, len(r.slice))
+	copy(products, r.slice)
+	return products, nil
 }
 
 func (r *ProductSlice) GetProductById(id int) (*product.Product, error) {
-	for _, product := range r.slice {
-		if product.Id == id {
-			return &product, nil
-		}
-	}
-	return nil, product.ErrProdNotFound
+	r.mu.RLock()
+	defer r.mu.RUnlock()
 
+	return r.getProductById(id)
 }
 
 func (r *ProductSlice) FindProductsByPriceGt(price float64) []product.Product {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
 	var productsFound []product.Product
 	for _, product := range r.slice {
 		if product.Price > price {
@@ -58,29 +176,77 @@ func (r *ProductSlice) FindProductsByPriceGt(price float64) []product.Product {
 	return productsFound
 }
 
-func (r *ProductSlice) CreateProduct(p *product.Product) (err error) {
+// FindProducts scans the slice, which is kept in id order
+func (r *ProductSlice) FindProducts(filter product.Filter, page product.Page) (product.ProductPage, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
 
-	p.Id = len(r.slice) + 1
-	r.slice = append(r.slice, *p)
+	return findPage(page, func(add func(product.Product)) error {
+		for _, p := range r.slice {
+			if filter.Match(p) {
+				add(p)
+			}
+		}
+		return nil
+	})
+}
 
-	//save slice to storage
-	data, err := json.Marshal(r.slice)
-	if err != nil {
-		return err
+func (r *ProductSlice) GetProductByCode(code string) (*product.Product, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	ids := r.codes[code]
+	if len(ids) == 0 {
+		return nil, product.ErrProdNotFound
 	}
-	err = r.storage.Write(data)
-	if err != nil {
+	return r.getProductById(ids[0])
+}
+
+// SearchProducts ranks the names kept in the in-memory index
+func (r *ProductSlice) SearchProducts(query string, page product.Page) (product.ProductPage, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return searchPage(query, page, r.names.scan, func(id int) (product.Product, error) {
+		return r.slice[r.index[id]], nil
+	})
+}
+
+func (r *ProductSlice) CreateProduct(p *product.Product) (err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.checkCode(0, p.CodeValue); err != nil {
 		return err
 	}
+	created := *p
+	created.Id = r.lastId + 1
+	undo := r.put(created)
 
+	//save slice to storage
+	if err := r.commit(opCreate, created, undo); err != nil {
+		return err
+	}
+	p.Id = created.Id
 	return nil
 }
 
 func (r *ProductSlice) UpdateOrCreateProduct(p *product.RequestBodyProduct, id int) error {
-	_, err := r.GetProductById(id)
-	if err != nil {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	i, ok := r.index[id]
+	// a created product gets a new id, any product using the code is another
+	owner := id
+	if !ok {
+		owner = 0
+	}
+	if err := r.checkCode(owner, p.CodeValue); err != nil {
+		return err
+	}
+	if !ok {
 		newProduct := product.Product{
-			Id:           len(r.slice) + 1,
+			Id:           r.lastId + 1,
 			Name:         p.Name,
 			Quantity:     p.Quantity,
 			CodeValue:    p.CodeValue,
@@ -88,91 +254,237 @@ func (r *ProductSlice) UpdateOrCreateProduct(p *product.RequestBodyProduct, id i
 			Expiration:   p.Expiration,
 			Price:        p.Price,
 		}
-		r.slice = append(r.slice, *&newProduct)
-	} else {
-		product := r.slice[(id - 1)]
-		product.Name = p.Name
-		product.Quantity = p.Quantity
-		product.CodeValue = p.CodeValue
-		product.Is_Published = p.Is_Published
-		product.Expiration = p.Expiration
-		product.Price = p.Price
-		r.slice[(id - 1)] = product
+		undo := r.put(newProduct)
+		return r.commit(opCreate, newProduct, undo)
 	}
 
+	product := r.slice[i]
+	product.Name = p.Name
+	product.Quantity = p.Quantity
+	product.CodeValue = p.CodeValue
+	product.Is_Published = p.Is_Published
+	product.Expiration = p.Expiration
+	product.Price = p.Price
+	undo := r.put(product)
+
 	//save slice to storage
-	data, err := json.Marshal(r.slice)
+	return r.commit(opUpdate, product, undo)
+}
+
+// UpdatePartial updates a product by id
+func (r *ProductSlice) UpdatePartial(p map[string]interface{}, id int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	product, err := r.getProductById(id)
 	if err != nil {
 		return err
 	}
-	err = r.storage.Write(data)
-	if err != nil {
+	if err := applyFields(product, p); err != nil {
 		return err
 	}
+	if err := r.checkCode(id, product.CodeValue); err != nil {
+		return err
+	}
+	undo := r.put(*product)
+
+	//save slice to storage
+	return r.commit(opUpdate, *product, undo)
+}
+
+func (r *ProductSlice) DeleteProduct(id int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	undo, ok := r.remove(id)
+	if !ok {
+		return product.ErrProdNotFound
+	}
+
+	// Save slice to storage
+	return r.commit(opDelete, product.Product{Id: id}, undo)
+}
+
+// getProductById expects mu to be held by the caller
+func (r *ProductSlice) getProductById(id int) (*product.Product, error) {
+	i, ok := r.index[id]
+	if !ok {
+		return nil, product.ErrProdNotFound
+	}
+	p := r.slice[i]
+	return &p, nil
+}
+
+// checkCode returns ErrProdDuplicateCode when a product other than the one
+// with the given id uses code, expects mu to be held by the caller. A
+// product keeping its code passes, so the ones sharing a code loaded from
+// an older file can still be edited.
+func (r *ProductSlice) checkCode(id int, code string) error {
+	if i, ok := r.index[id]; ok && r.slice[i].CodeValue == code {
+		return nil
+	}
+	for _, other := range r.codes[code] {
+		if other != id {
+			return fmt.Errorf("%w: %q", product.ErrProdDuplicateCode, code)
+		}
+	}
 	return nil
 }
 
-// UpdatePartial updates a product by id
-func (r *ProductSlice) UpdatePartial(p map[string]interface{}, id int) error {
-	product, err := r.GetProductById(id)
-	if err != nil {
+// put inserts or replaces p, expects mu to be held by the caller. The
+// returned func undoes it.
+func (r *ProductSlice) put(p product.Product) (undo func()) {
+	if i, ok := r.index[p.Id]; ok {
+		previous := r.slice[i]
+		r.names.put(p.Id, p.Name)
+		r.unindexCode(previous)
+		r.indexCode(p)
+		r.slice[i] = p
+		return func() { r.put(previous) }
+	}
+
+	lastId := r.lastId
+	r.insert(len(r.slice), p)
+	if p.Id > r.lastId {
+		r.lastId = p.Id
+	}
+	return func() {
+		r.remove(p.Id)
+		r.lastId = lastId
+	}
+}
+
+// remove deletes the product with the given id, expects mu to be held by
+// the caller. It reports whether the product existed, and returns a func
+// undoing it when it did.
+func (r *ProductSlice) remove(id int) (undo func(), ok bool) {
+	i, ok := r.index[id]
+	if !ok {
+		return nil, false
+	}
+
+	removed := r.slice[i]
+	r.unindexCode(removed)
+	r.slice = slices.Delete(r.slice, i, i+1)
+	delete(r.index, id)
+	r.names.remove(id)
+	for j := i; j < len(r.slice); j++ {
+		r.index[r.slice[j].Id] = j
+	}
+	return func() { r.insert(i, removed) }, true
+}
+
+// insert adds p at position i of the slice, expects mu to be held by the
+// caller
+func (r *ProductSlice) insert(i int, p product.Product) {
+	r.slice = slices.Insert(r.slice, i, p)
+	for j := i; j < len(r.slice); j++ {
+		r.index[r.slice[j].Id] = j
+	}
+	r.names.put(p.Id, p.Name)
+	r.indexCode(p)
+}
+
+// indexCode adds p to codes, expects mu to be held by the caller
+func (r *ProductSlice) indexCode(p product.Product) {
+	ids := r.codes[p.CodeValue]
+	i, _ := slices.BinarySearch(ids, p.Id)
+	r.codes[p.CodeValue] = slices.Insert(ids, i, p.Id)
+}
+
+// unindexCode drops p from codes, expects mu to be held by the caller
+func (r *ProductSlice) unindexCode(p product.Product) {
+	ids := r.codes[p.CodeValue]
+	if i, ok := slices.BinarySearch(ids, p.Id); ok {
+		ids = slices.Delete(ids, i, i+1)
+	}
+	if len(ids) == 0 {
+		delete(r.codes, p.CodeValue)
+		return
+	}
+	r.codes[p.CodeValue] = ids
+}
+
+// commit persists a change already made in memory, expects mu to be held
+// by the caller. When another process changed the storage the change is
+// dropped and its products are loaded instead, so the caller can retry
+// against them. On any other failure undo restores the products served.
+func (r *ProductSlice) commit(op string, p product.Product, undo func()) error {
+	err := r.persist(op, p)
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, storage.ErrConflict) {
+		undo()
 		return err
 	}
-	for key, value := range p {
-		switch key {
-		case "name":
-			product.Name = value.(string)
-		case "quantity":
-			product.Quantity = value.(int)
-		case "code_value":
-			product.CodeValue = value.(string)
-		case "is_published":
-			product.Is_Published = value.(bool)
-		case "expiration":
-			product.Expiration = value.(string)
-		case "price":
-			product.Price = value.(float64)
-		default:
-			return errors.New("invalid field")
-		}
+	if _, reloadErr := r.reload(); reloadErr != nil {
+		undo()
+		err = errors.Join(err, reloadErr)
 	}
-	r.slice[(id - 1)] = *product
+	return fmt.Errorf("%w: %w", product.ErrProdConflict, err)
+}
 
-	//save slice to storage
-	data, err := json.Marshal(r.slice)
+// persist writes a change. Journals record just the change and get a full
+// snapshot once they ask for one, other storages are rewritten entirely.
+func (r *ProductSlice) persist(op string, p product.Product) error {
+	journal, ok := r.storage.(storage.Journal)
+	if !ok {
+		return r.save()
+	}
+
+	data, err := json.Marshal(productsEntry{Op: op, Product: p})
 	if err != nil {
 		return err
 	}
-	err = r.storage.Write(data)
+	compact, err := journal.Append(data)
 	if err != nil {
 		return err
 	}
+	// the change is kept once appended, so it must not be undone when the
+	// compaction fails: the next change retries it. When another process
+	// changed the files since, its changes are loaded along with this one.
+	// A reload that fails is retried by the next change, which conflicts.
+	if compact {
+		if err := r.save(); errors.Is(err, storage.ErrConflict) {
+			r.reload()
+		}
+	}
 	return nil
 }
 
-func (r *ProductSlice) DeleteProduct(id int) error {
-	_, err := r.GetProductById(id)
-	if err != nil {
-		return product.ErrProdNotFound
+// apply replays a journal entry. Entries hold whole products so replaying
+// one the snapshot already includes changes nothing.
+func (r *ProductSlice) apply(data []byte) error {
+	var e productsEntry
+	if err := json.Unmarshal(data, &e); err != nil {
+		return err
 	}
 
-	// Delete product
-	for i, product := range r.slice {
-		if product.Id == id {
-			r.slice = append(r.slice[:i], r.slice[i+1:]...)
-			break
+	switch e.Op {
+	case opCreate, opUpdate:
+		r.put(e.Product)
+	case opDelete:
+		r.remove(e.Product.Id)
+		if e.Product.Id > r.lastId {
+			r.lastId = e.Product.Id
 		}
+	default:
+		return fmt.Errorf("unknown journal operation %q", e.Op)
 	}
+	return nil
+}
 
-	// Save slice to storage
-	data, err := json.Marshal(r.slice)
-	if err != nil {
-		return err
-	}
-	err = r.storage.Write(data)
+// save writes the slice and the id sequence to storage, expects mu to be
+// held by the caller
+func (r *ProductSlice) save() error {
+	data, err := json.Marshal(productsDocument{
+		SchemaVersion: SchemaVersion,
+		LastId:        r.lastId,
+		Products:      r.slice,
+	})
 	if err != nil {
 		return err
 	}
-	return nil
-
+	return r.storage.Write(data)
 }
@@ -0,0 +1,427 @@
+package repository
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"web/clase1/internal"
+	"web/clase1/internal/repository/repositorytest"
+	"web/clase1/internal/storage"
+
+	"github.com/stretchr/testify/require"
+	bolt "go.etcd.io/bbolt"
+)
+
+type testBackend struct {
+	name string
+	open repositorytest.Factory
+}
+
+var testBackends = []testBackend{
+	{
+		name: "slice",
+		open: func(t *testing.T, seed []product.Product) (product.ProductRepository, func() product.ProductRepository) {
+			path := seedProductsFile(t, seed)
+			reopen := func() product.ProductRepository {
+				rp, err := NewProductRepository(storage.NewStorageJSON(path))
+				require.NoError(t, err)
+				return rp
+			}
+			return reopen(), reopen
+		},
+	},
+	{
+		name: "memory",
+		open: func(t *testing.T, seed []product.Product) (product.ProductRepository, func() product.ProductRepository) {
+			st := repositorytest.NewFixture().With(seed...).Storage()
+			reopen := func() product.ProductRepository {
+				rp, err := NewProductRepository(st)
+				require.NoError(t, err)
+				return rp
+			}
+			return reopen(), reopen
+		},
+	},
+	{
+		name: "csv",
+		open: func(t *testing.T, seed []product.Product) (product.ProductRepository, func() product.ProductRepository) {
+			path := filepath.Join(t.TempDir(), "products.csv")
+			data, err := json.Marshal(seed)
+			require.NoError(t, err)
+			require.NoError(t, storage.NewStorageCSVFile(path, 0).Write(data))
+			reopen := func() product.ProductRepository {
+				rp, err := NewProductRepository(storage.NewStorageCSVFile(path, 0))
+				require.NoError(t, err)
+				return rp
+			}
+			return reopen(), reopen
+		},
+	},
+	{
+		name: "wal",
+		open: func(t *testing.T, seed []product.Product) (product.ProductRepository, func() product.ProductRepository) {
+			dir := t.TempDir()
+			return openFileBackend(t, seed, Config{
+				Backend:      BackendWAL,
+				Path:         filepath.Join(dir, "products.json"),
+				LogPath:      filepath.Join(dir, "products.wal"),
+				CompactAfter: 4,
+			})
+		},
+	},
+	{
+		name: "json+compressed",
+		open: func(t *testing.T, seed []product.Product) (product.ProductRepository, func() product.ProductRepository) {
+			return openFileBackend(t, seed, Config{Path: filepath.Join(t.TempDir(), "products.json.zst")})
+		},
+	},
+	{
+		name: "json+encrypted",
+		open: func(t *testing.T, seed []product.Product) (product.ProductRepository, func() product.ProductRepository) {
+			dir := t.TempDir()
+			return openFileBackend(t, seed, Config{
+				Path:              filepath.Join(dir, "products.json"),
+				EncryptionKeyFile: writeEncryptionKey(t, dir),
+			})
+		},
+	},
+	{
+		name: "sqlite",
+		open: func(t *testing.T, seed []product.Product) (product.ProductRepository, func() product.ProductRepository) {
+			rp, path := newTestSQLite(t)
+			for _, p := range seed {
+				require.NoError(t, rp.update(func(tx *sql.Tx) error {
+					_, err := tx.Exec(`INSERT INTO products (`+productColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+						p.Id, p.Name, p.Quantity, p.CodeValue, p.Is_Published, p.Expiration, p.Price)
+					if err != nil {
+						return err
+					}
+					return indexNameSQL(tx, p.Id, p.Name)
+				}))
+			}
+			reopen := func() product.ProductRepository {
+				require.NoError(t, rp.Close())
+				var err error
+				rp, err = OpenProductSQLite(path)
+				require.NoError(t, err)
+				return rp
+			}
+			return rp, reopen
+		},
+	},
+	{
+		name: "bolt",
+		open: func(t *testing.T, seed []product.Product) (product.ProductRepository, func() product.ProductRepository) {
+			path := filepath.Join(t.TempDir(), "products.bolt")
+			rp, err := OpenProductBolt(path)
+			require.NoError(t, err)
+			t.Cleanup(func() { rp.Close() })
+			for _, p := range seed {
+				require.NoError(t, rp.db.Update(func(tx *bolt.Tx) error {
+					return putProductBolt(tx.Bucket(productsBucket), p)
+				}))
+			}
+			reopen := func() product.ProductRepository {
+				require.NoError(t, rp.Close())
+				rp, err = OpenProductBolt(path)
+				require.NoError(t, err)
+				return rp
+			}
+			return rp, reopen
+		},
+	},
+}
+
+// openFileBackend writes seed to the storage cfg configures and opens a
+// ProductSlice over it, reopen opens another one over the same files
+func openFileBackend(t *testing.T, seed []product.Product, cfg Config) (product.ProductRepository, func() product.ProductRepository) {
+	t.Helper()
+
+	st, err := fileStorage(cfg)
+	require.NoError(t, err)
+	data, err := json.Marshal(seed)
+	require.NoError(t, err)
+	require.NoError(t, st.Write(data))
+	reopen := func() product.ProductRepository {
+		st, err := fileStorage(cfg)
+		require.NoError(t, err)
+		rp, err := NewProductRepository(st)
+		require.NoError(t, err)
+		return rp
+	}
+	return reopen(), reopen
+}
+
+// seedProductsFile writes products to a temp products file
+func seedProductsFile(t *testing.T, products []product.Product) string {
+	t.Helper()
+
+	data, err := json.Marshal(products)
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "products.json")
+	require.NoError(t, os.WriteFile(path, data, 0644))
+	return path
+}
+
+// openProductSlice loads st, failing the test if it can't
+func openProductSlice(t *testing.T, st storage.Storage) *ProductSlice {
+	t.Helper()
+
+	rp, err := NewProductRepository(st)
+	require.NoError(t, err)
+	return rp
+}
+
+// failingStorage fails its writes while fail is set
+type failingStorage struct {
+	storage.Storage
+	fail bool
+}
+
+var errWriteFailed = errors.New("write failed")
+
+func (s *failingStorage) Write(data []byte) error {
+	if s.fail {
+		return errWriteFailed
+	}
+	return s.Storage.Write(data)
+}
+
+func TestConformance(t *testing.T) {
+	for _, b := range testBackends {
+		t.Run(b.name, func(t *testing.T) {
+			repositorytest.Run(t, b.open)
+		})
+	}
+}
+
+func TestProductSlice(t *testing.T) {
+	t.Run("should reject a file with duplicated ids", func(t *testing.T) {
+		// Arrange
+		path := filepath.Join(t.TempDir(), "products.json")
+		require.NoError(t, os.WriteFile(path, []byte(`[{"id":3},{"id":3}]`), 0644))
+		// Act
+		_, err := NewProductRepository(storage.NewStorageJSON(path))
+		// Assert
+		require.EqualError(t, err, "duplicated product id 3")
+	})
+	t.Run("should load a file with duplicated codes, edit them and keep changes from adding more", func(t *testing.T) {
+		// Arrange
+		path := filepath.Join(t.TempDir(), "products.json")
+		require.NoError(t, os.WriteFile(path, []byte(`[{"id":2,"code_value":"A"},{"id":1,"code_value":"A"}]`), 0644))
+		// Act
+		rp, err := NewProductRepository(storage.NewStorageJSON(path))
+		require.NoError(t, err)
+		// Assert
+		p, err := rp.GetProductByCode("A")
+		require.NoError(t, err)
+		require.Equal(t, 1, p.Id)
+		require.NoError(t, rp.UpdatePartial(map[string]any{"name": "renamed"}, 2))
+		require.NoError(t, rp.UpdateOrCreateProduct(&product.RequestBodyProduct{Name: "replaced", CodeValue: "A"}, 1))
+		require.ErrorIs(t, rp.CreateProduct(&product.Product{Name: "new", CodeValue: "A"}), product.ErrProdDuplicateCode)
+		require.NoError(t, rp.UpdatePartial(map[string]any{"code_value": "B"}, 2))
+		require.ErrorIs(t, rp.UpdatePartial(map[string]any{"code_value": "A"}, 2), product.ErrProdDuplicateCode)
+		require.NoError(t, rp.DeleteProduct(1))
+		_, err = rp.GetProductByCode("A")
+		require.ErrorIs(t, err, product.ErrProdNotFound)
+	})
+	t.Run("should replay the journal on startup and compact it", func(t *testing.T) {
+		// Arrange
+		path := seedProductsFile(t, repositorytest.Products(3))
+		logName := filepath.Join(filepath.Dir(path), "products.wal")
+		rp, err := NewProductRepository(storage.NewStorageWAL(path, logName, 3))
+		require.NoError(t, err)
+
+		// Act
+		p := product.Product{Name: "new", CodeValue: "N"}
+		require.NoError(t, rp.CreateProduct(&p))
+		require.NoError(t, rp.UpdatePartial(map[string]any{"name": "renamed"}, 1))
+		snapshot, err := os.ReadFile(path)
+		require.NoError(t, err)
+		restarted := openProductSlice(t, storage.NewStorageWAL(path, logName, 3))
+
+		// Assert
+		require.NotContains(t, string(snapshot), "renamed")
+		expected, err := rp.GetAllProducts()
+		require.NoError(t, err)
+		products, err := restarted.GetAllProducts()
+		require.NoError(t, err)
+		require.Equal(t, expected, products)
+
+		// the third change reaches the threshold and writes a snapshot
+		require.NoError(t, restarted.DeleteProduct(2))
+		log, err := os.ReadFile(logName)
+		require.NoError(t, err)
+		require.Empty(t, log)
+		p = product.Product{Name: "newer", CodeValue: "NN"}
+		require.NoError(t, openProductSlice(t, storage.NewStorageWAL(path, logName, 3)).CreateProduct(&p))
+		require.Equal(t, 5, p.Id)
+	})
+	t.Run("should not overwrite the changes of another process", func(t *testing.T) {
+		// Arrange
+		path := seedProductsFile(t, repositorytest.Products(3))
+		first := openProductSlice(t, storage.NewStorageJSON(path))
+		second := openProductSlice(t, storage.NewStorageJSON(path))
+		require.NoError(t, first.CreateProduct(&product.Product{Name: "first", CodeValue: "F"}))
+
+		// Act
+		err := second.CreateProduct(&product.Product{Name: "second", CodeValue: "S"})
+
+		// Assert
+		require.ErrorIs(t, err, product.ErrProdConflict)
+		p, err := second.GetProductById(4)
+		require.NoError(t, err)
+		require.Equal(t, "first", p.Name)
+		retry := product.Product{Name: "second", CodeValue: "S"}
+		require.NoError(t, second.CreateProduct(&retry))
+		require.Equal(t, 5, retry.Id)
+	})
+	t.Run("should not interleave the journal entries of another process", func(t *testing.T) {
+		// Arrange
+		dir := t.TempDir()
+		path, logName := seedProductsFile(t, repositorytest.Products(3)), filepath.Join(dir, "products.wal")
+		first := openProductSlice(t, storage.NewStorageWAL(path, logName, 0))
+		second := openProductSlice(t, storage.NewStorageWAL(path, logName, 0))
+		require.NoError(t, first.CreateProduct(&product.Product{Name: "first", CodeValue: "F"}))
+
+		// Act
+		err := second.CreateProduct(&product.Product{Name: "second", CodeValue: "S"})
+
+		// Assert
+		require.ErrorIs(t, err, product.ErrProdConflict)
+		retry := product.Product{Name: "second", CodeValue: "S"}
+		require.NoError(t, second.CreateProduct(&retry))
+		require.Equal(t, 5, retry.Id)
+		stored, err := openProductSlice(t, storage.NewStorageWAL(path, logName, 0)).GetAllProducts()
+		require.NoError(t, err)
+		require.Len(t, stored, 5)
+		require.Equal(t, "first", stored[3].Name)
+		require.Equal(t, "second", stored[4].Name)
+	})
+	t.Run("should load the snapshot compacted by another process", func(t *testing.T) {
+		// Arrange
+		dir := t.TempDir()
+		path, logName := seedProductsFile(t, repositorytest.Products(3)), filepath.Join(dir, "products.wal")
+		first := openProductSlice(t, storage.NewStorageWAL(path, logName, 1))
+		second := openProductSlice(t, storage.NewStorageWAL(path, logName, 1))
+		require.NoError(t, first.DeleteProduct(1))
+
+		// Act
+		err := second.DeleteProduct(2)
+
+		// Assert
+		require.ErrorIs(t, err, product.ErrProdConflict)
+		require.NoError(t, second.DeleteProduct(2))
+		products, err := second.GetAllProducts()
+		require.NoError(t, err)
+		require.Equal(t, repositorytest.Products(3)[2:], products)
+	})
+	t.Run("should not reuse the ids of another process when its products match", func(t *testing.T) {
+		// Arrange
+		path := seedProductsFile(t, repositorytest.Products(3))
+		first := openProductSlice(t, storage.NewStorageJSON(path))
+		second := openProductSlice(t, storage.NewStorageJSON(path))
+		p := product.Product{Name: "first", CodeValue: "F"}
+		require.NoError(t, first.CreateProduct(&p))
+		require.NoError(t, first.DeleteProduct(p.Id))
+		require.NoError(t, first.DeleteProduct(3))
+
+		// Act
+		err := second.DeleteProduct(3)
+
+		// Assert
+		require.ErrorIs(t, err, product.ErrProdConflict)
+		retry := product.Product{Name: "second", CodeValue: "S"}
+		require.NoError(t, second.CreateProduct(&retry))
+		require.Equal(t, 5, retry.Id)
+	})
+	t.Run("should keep serving the products when a change can't be saved", func(t *testing.T) {
+		// Arrange
+		st := &failingStorage{Storage: repositorytest.NewFixture().WithN(3).Storage(), fail: true}
+		rp := openProductSlice(t, st)
+		expected, err := rp.GetAllProducts()
+		require.NoError(t, err)
+
+		// Act
+		created := product.Product{Name: "new", CodeValue: "N"}
+		createErr := rp.CreateProduct(&created)
+		putErr := rp.UpdateOrCreateProduct(&product.RequestBodyProduct{Name: "put", CodeValue: "P"}, 9)
+		patchErr := rp.UpdatePartial(map[string]any{"name": "renamed", "code_value": "R"}, 1)
+		deleteErr := rp.DeleteProduct(2)
+
+		// Assert
+		for _, err := range []error{createErr, putErr, patchErr, deleteErr} {
+			require.ErrorIs(t, err, errWriteFailed)
+		}
+		require.Zero(t, created.Id)
+		products, err := rp.GetAllProducts()
+		require.NoError(t, err)
+		require.Equal(t, expected, products)
+		found, err := rp.SearchProducts("product", product.Page{})
+		require.NoError(t, err)
+		require.Len(t, found.Products, 3)
+		for _, code := range []string{"N", "P", "R"} {
+			_, err = rp.GetProductByCode(code)
+			require.ErrorIs(t, err, product.ErrProdNotFound)
+		}
+		p, err := rp.GetProductByCode("C2")
+		require.NoError(t, err)
+		require.Equal(t, 2, p.Id)
+
+		st.fail = false
+		require.NoError(t, rp.CreateProduct(&created))
+		require.Equal(t, 4, created.Id)
+	})
+	t.Run("should keep serving the products when the conflicting file can't be loaded", func(t *testing.T) {
+		// Arrange
+		path := seedProductsFile(t, repositorytest.Products(3))
+		rp := openProductSlice(t, storage.NewStorageJSON(path))
+		require.NoError(t, os.WriteFile(path, []byte(`[{"id":1},`), 0644))
+
+		// Act
+		err := rp.CreateProduct(&product.Product{Name: "new", CodeValue: "N"})
+
+		// Assert
+		require.ErrorIs(t, err, product.ErrProdConflict)
+		products, err := rp.GetAllProducts()
+		require.NoError(t, err)
+		require.Equal(t, repositorytest.Products(3), products)
+		_, err = rp.GetProductByCode("N")
+		require.ErrorIs(t, err, product.ErrProdNotFound)
+	})
+}
+
+func TestProductBolt(t *testing.T) {
+	t.Run("should edit products stored with duplicated codes but keep changes from adding more", func(t *testing.T) {
+		// Arrange
+		path := filepath.Join(t.TempDir(), "products.bolt")
+		rp, err := OpenProductBolt(path)
+		require.NoError(t, err)
+		// products stored before the codes bucket, which may share a code
+		require.NoError(t, rp.db.Update(func(tx *bolt.Tx) error {
+			for id := 1; id <= 2; id++ {
+				data, err := json.Marshal(product.Product{Id: id, CodeValue: "A"})
+				require.NoError(t, err)
+				if err := tx.Bucket(productsBucket).Put(boltKey(id), data); err != nil {
+					return err
+				}
+			}
+			return tx.DeleteBucket(codesBucket)
+		}))
+		require.NoError(t, rp.Close())
+		rp, err = OpenProductBolt(path)
+		require.NoError(t, err)
+		defer rp.Close()
+		// Act
+		err = rp.UpdatePartial(map[string]any{"name": "renamed"}, 2)
+		// Assert
+		require.NoError(t, err)
+		require.ErrorIs(t, rp.CreateProduct(&product.Product{Name: "new", CodeValue: "A"}), product.ErrProdDuplicateCode)
+		require.NoError(t, rp.UpdatePartial(map[string]any{"code_value": "B"}, 2))
+		require.ErrorIs(t, rp.UpdatePartial(map[string]any{"code_value": "A"}, 2), product.ErrProdDuplicateCode)
+	})
+}
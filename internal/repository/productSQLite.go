@@ -0,0 +1,602 @@
+package repository
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+	"web/clase1/internal"
+
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// migrations are applied in order, the schema version is the number of
+// migrations applied. Never edit a released migration, append a new one.
+var migrations = []string{
+	`CREATE TABLE products (
+		id           INTEGER PRIMARY KEY AUTOINCREMENT,
+		name         TEXT    NOT NULL,
+		quantity     INTEGER NOT NULL,
+		code_value   TEXT    NOT NULL UNIQUE,
+		is_published INTEGER NOT NULL,
+		expiration   TEXT    NOT NULL,
+		price        REAL    NOT NULL
+	)`,
+	`CREATE INDEX products_price ON products (price)`,
+	`CREATE TABLE product_terms (
+		term       TEXT    NOT NULL,
+		product_id INTEGER NOT NULL,
+		PRIMARY KEY (term, product_id)
+	) WITHOUT ROWID`,
+	`CREATE INDEX product_terms_product ON product_terms (product_id)`,
+	// index the names stored before product_terms, splitting the terms
+	// search_terms joins with spaces
+	`WITH RECURSIVE split (id, term, rest) AS (
+		SELECT id, '', search_terms(name) || ' ' FROM products
+		UNION ALL
+		SELECT id, substr(rest, 1, instr(rest, ' ') - 1), substr(rest, instr(rest, ' ') + 1) FROM split WHERE rest <> ''
+	)
+	INSERT INTO product_terms (term, product_id) SELECT DISTINCT term, id FROM split WHERE term <> ''`,
+}
+
+const (
+	productColumns = "id, name, quantity, code_value, is_published, expiration, price"
+	// queryPriceGt forces the price index, the planner would otherwise scan
+	// by id to skip sorting
+	queryPriceGt = `SELECT ` + productColumns + ` FROM products INDEXED BY products_price WHERE price > ? ORDER BY id`
+)
+
+// init registers the Go functions product.Filter matches with, so SQL
+// lowercases and parses dates exactly like it does, and the one splitting
+// names into search terms
+func init() {
+	lower := func(_ *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+		s, _ := args[0].(string)
+		return strings.ToLower(s), nil
+	}
+	// expiration_date is the ISO date of an expiration, NULL if it doesn't
+	// parse
+	expirationDate := func(_ *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+		s, _ := args[0].(string)
+		date, err := product.Product{Expiration: s}.ExpirationDate()
+		if err != nil {
+			return nil, nil
+		}
+		return date.Format(time.DateOnly), nil
+	}
+	terms := func(_ *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+		s, _ := args[0].(string)
+		return strings.Join(searchTerms(s), " "), nil
+	}
+	if err := sqlite.RegisterDeterministicScalarFunction("go_lower", 1, lower); err != nil {
+		panic(err)
+	}
+	if err := sqlite.RegisterDeterministicScalarFunction("expiration_date", 1, expirationDate); err != nil {
+		panic(err)
+	}
+	if err := sqlite.RegisterDeterministicScalarFunction("search_terms", 1, terms); err != nil {
+		panic(err)
+	}
+}
+
+// ProductSQLite is a ProductRepository backed by a SQLite database.
+// AUTOINCREMENT keeps ids from being reused after deletes.
+type ProductSQLite struct {
+	db *sql.DB
+}
+
+// OpenProductSQLite opens the database file at path, creating it if needed
+func OpenProductSQLite(path string) (*ProductSQLite, error) {
+	// escaped as an URI, so that paths may contain '?' or '#'
+	dsn := url.URL{
+		Scheme:   "file",
+		Path:     path,
+		RawQuery: "_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)",
+	}
+	db, err := sql.Open("sqlite", dsn.String())
+	if err != nil {
+		return nil, err
+	}
+	// sqlite allows a single writer, serialize access instead of failing
+	// with SQLITE_BUSY
+	db.SetMaxOpenConns(1)
+
+	r, err := NewProductSQLite(db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// NewProductSQLite migrates db to the latest schema version
+func NewProductSQLite(db *sql.DB) (*ProductSQLite, error) {
+	if err := migrate(db); err != nil {
+		return nil, err
+	}
+	return &ProductSQLite{
+		db: db,
+	}, nil
+}
+
+func migrate(db *sql.DB) error {
+	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER NOT NULL)`); err != nil {
+		return err
+	}
+
+	var version int
+	if err := db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version); err != nil {
+		return err
+	}
+	if version > len(migrations) {
+		return fmt.Errorf("database schema version %d is newer than this build (%d)", version, len(migrations))
+	}
+
+	for v := version; v < len(migrations); v++ {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(migrations[v]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d: %w", v+1, err)
+		}
+		if _, err := tx.Exec(`INSERT INTO schema_migrations (version) VALUES (?)`, v+1); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// update runs fn in a write transaction, committed when it returns nil
+func (r *ProductSQLite) update(fn func(tx *sql.Tx) error) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *ProductSQLite) Close() error {
+	return r.db.Close()
+}
+
+func (r *ProductSQLite) GetAllProducts() ([]product.Product, error) {
+	products, err := r.query(`SELECT ` + productColumns + ` FROM products ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	if len(products) == 0 {
+		return nil, errors.New("no products found")
+	}
+	return products, nil
+}
+
+func (r *ProductSQLite) GetProductById(id int) (*product.Product, error) {
+	return getProductSQL(r.db, id)
+}
+
+// GetProductByCode uses the index of the UNIQUE constraint on code_value
+func (r *ProductSQLite) GetProductByCode(code string) (*product.Product, error) {
+	return findProductSQL(r.db, `code_value = ?`, code)
+}
+
+// codeError turns the violation of the UNIQUE constraint on code_value, the
+// only one of products, into ErrProdDuplicateCode
+func codeError(err error, code string) error {
+	var sqliteErr *sqlite.Error
+	if errors.As(err, &sqliteErr) && sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE {
+		return fmt.Errorf("%w: %q", product.ErrProdDuplicateCode, code)
+	}
+	return err
+}
+
+// FindProductsByPriceGt uses the products_price index
+func (r *ProductSQLite) FindProductsByPriceGt(price float64) []product.Product {
+	products, err := r.query(queryPriceGt, price)
+	if err != nil {
+		return nil
+	}
+	return products
+}
+
+// FindProducts filters, counts and pages in SQL, in a single read
+// transaction so the total agrees with the page
+func (r *ProductSQLite) FindProducts(filter product.Filter, page product.Page) (product.ProductPage, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return product.ProductPage{}, err
+	}
+	defer tx.Rollback()
+
+	where, args := filterSQL(filter)
+	count := func(condition string, args ...any) (n int, err error) {
+		err = tx.QueryRow(`SELECT COUNT(*) FROM products WHERE `+condition, args...).Scan(&n)
+		return
+	}
+	total, err := count(where, args...)
+	if err != nil {
+		return product.ProductPage{}, err
+	}
+
+	// SQLite takes a negative LIMIT as no limit
+	limit := page.Limit
+	if limit == 0 {
+		limit = -1
+	}
+	selectWhere := `SELECT ` + productColumns + ` FROM products WHERE ` + where
+	var products []product.Product
+	var before int
+	cursor := page.Cursor
+	if cursor == nil {
+		products, err = queryProducts(tx, selectWhere+` ORDER BY `+orderSQL(page.Sort, false)+` LIMIT ? OFFSET ?`, append(args, limit, page.Offset)...)
+	} else {
+		keyset, keyArgs := keysetSQL(page.Sort, *cursor)
+		args = append(args, keyArgs...)
+		// before counts the products up to a forward cursor, or before a
+		// backward one
+		beforeWhere := where + ` AND NOT (` + keyset + `)`
+		if cursor.Backward {
+			beforeWhere = where + ` AND (` + keyset + `)`
+		}
+		if before, err = count(beforeWhere, args...); err != nil {
+			return product.ProductPage{}, err
+		}
+		products, err = queryProducts(tx, selectWhere+` AND (`+keyset+`) ORDER BY `+orderSQL(page.Sort, cursor.Backward)+` LIMIT ?`, append(args, limit)...)
+		if cursor.Backward {
+			slices.Reverse(products)
+		}
+	}
+	if err != nil {
+		return product.ProductPage{}, err
+	}
+	if products == nil {
+		products = []product.Product{}
+	}
+	return pageOf(page, products, total, before), nil
+}
+
+// filterSQL translates filter to a WHERE condition and its arguments. It
+// uses the functions registered in init to match like product.Filter.
+func filterSQL(filter product.Filter) (string, []any) {
+	where := []string{"1"}
+	var args []any
+	add := func(condition string, arg any) {
+		where = append(where, condition)
+		args = append(args, arg)
+	}
+	if filter.PriceGt != nil {
+		add("price > ?", *filter.PriceGt)
+	}
+	if filter.PriceGte != nil {
+		add("price >= ?", *filter.PriceGte)
+	}
+	if filter.PriceLte != nil {
+		add("price <= ?", *filter.PriceLte)
+	}
+	if filter.QuantityGte != nil {
+		add("quantity >= ?", *filter.QuantityGte)
+	}
+	if filter.QuantityLte != nil {
+		add("quantity <= ?", *filter.QuantityLte)
+	}
+	if filter.IsPublished != nil {
+		add("is_published = ?", *filter.IsPublished)
+	}
+	if filter.CodePrefix != "" {
+		// instr compares exactly, LIKE would ignore case
+		add("instr(code_value, ?) = 1", filter.CodePrefix)
+	}
+	if filter.NameContains != "" {
+		add("instr(go_lower(name), ?) > 0", strings.ToLower(filter.NameContains))
+	}
+	// dates that don't parse are NULL and match neither bound
+	if filter.ExpirationBefore != nil {
+		add("expiration_date(expiration) < ?", filter.ExpirationBefore.Format(time.DateOnly))
+	}
+	if filter.ExpirationAfter != nil {
+		add("expiration_date(expiration) > ?", filter.ExpirationAfter.Format(time.DateOnly))
+	}
+	return strings.Join(where, " AND "), args
+}
+
+// sortColumns are the expressions ordering by each sortable field like
+// product.Compare, expirations that don't parse go first
+var sortColumns = map[string]string{
+	"id":           "id",
+	"name":         "name",
+	"quantity":     "quantity",
+	"code_value":   "code_value",
+	"is_published": "is_published",
+	"expiration":   "IFNULL(expiration_date(expiration), '')",
+	"price":        "price",
+}
+
+// sortKey is the value of p compared to the sortColumns of field
+func sortKey(p product.Product, field string) any {
+	switch field {
+	case "name":
+		return p.Name
+	case "quantity":
+		return p.Quantity
+	case "code_value":
+		return p.CodeValue
+	case "is_published":
+		return p.Is_Published
+	case "expiration":
+		return p.ExpirationISO()
+	case "price":
+		return p.Price
+	}
+	return p.Id
+}
+
+// orderSQL is the ORDER BY of sort with id as the tiebreaker, reversed for
+// backward cursors
+func orderSQL(sort []product.SortField, reverse bool) string {
+	terms := make([]string, 0, len(sort)+1)
+	for _, f := range append(slices.Clone(sort), product.SortField{Field: "id"}) {
+		term := sortColumns[f.Field]
+		if f.Desc != reverse {
+			term += " DESC"
+		}
+		terms = append(terms, term)
+	}
+	return strings.Join(terms, ", ")
+}
+
+// keysetSQL is the condition selecting the products cursor passes in the
+// order of sort, like product.Cursor.Passes. Each term ties the fields
+// before it and moves past the cursor on the next one.
+func keysetSQL(sort []product.SortField, cursor product.Cursor) (string, []any) {
+	fields := append(slices.Clone(sort), product.SortField{Field: "id"})
+	var terms []string
+	var args []any
+	for i, f := range fields {
+		var conditions []string
+		for _, tied := range fields[:i] {
+			conditions = append(conditions, sortColumns[tied.Field]+" = ?")
+			args = append(args, sortKey(cursor.Key, tied.Field))
+		}
+		op := ">"
+		if f.Desc != cursor.Backward {
+			op = "<"
+		}
+		// the last field is id, equal there means the key itself
+		if cursor.Inclusive && i == len(fields)-1 {
+			op += "="
+		}
+		conditions = append(conditions, sortColumns[f.Field]+" "+op+" ?")
+		args = append(args, sortKey(cursor.Key, f.Field))
+		terms = append(terms, "("+strings.Join(conditions, " AND ")+")")
+	}
+	return strings.Join(terms, " OR "), args
+}
+
+// SearchProducts ranks the names indexed in product_terms, in a single read
+// transaction so the products found are the ones indexed
+func (r *ProductSQLite) SearchProducts(query string, page product.Page) (product.ProductPage, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return product.ProductPage{}, err
+	}
+	defer tx.Rollback()
+
+	scan := func(from, to string, fn func(term string, ids []int)) error {
+		return scanTermsSQL(tx, from, to, fn)
+	}
+	return searchPage(query, page, scan, func(id int) (product.Product, error) {
+		p, err := getProductSQL(tx, id)
+		if err != nil {
+			return product.Product{}, err
+		}
+		return *p, nil
+	})
+}
+
+// scanTermsSQL reads the terms of product_terms from from up to to, see
+// scanTerms, seeking them in its primary key and calling fn once per term
+func scanTermsSQL(q querier, from, to string, fn func(term string, ids []int)) error {
+	query, args := `SELECT term, product_id FROM product_terms WHERE term >= ?`, []any{from}
+	if to != "" {
+		query, args = query+` AND term < ?`, append(args, to)
+	}
+	rows, err := q.Query(query+` ORDER BY term, product_id`, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var current string
+	var ids []int
+	for rows.Next() {
+		var term string
+		var id int
+		if err := rows.Scan(&term, &id); err != nil {
+			return err
+		}
+		if term != current && len(ids) > 0 {
+			fn(current, ids)
+			ids = nil
+		}
+		current = term
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(ids) > 0 {
+		fn(current, ids)
+	}
+	return nil
+}
+
+// indexNameSQL replaces the terms indexed for the product with the given id
+// by the ones of name
+func indexNameSQL(tx *sql.Tx, id int, name string) error {
+	if _, err := tx.Exec(`DELETE FROM product_terms WHERE product_id = ?`, id); err != nil {
+		return err
+	}
+	for _, term := range searchTerms(name) {
+		if _, err := tx.Exec(`INSERT INTO product_terms (term, product_id) VALUES (?, ?)`, term, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *ProductSQLite) CreateProduct(p *product.Product) error {
+	return r.update(func(tx *sql.Tx) error {
+		return createProductSQL(tx, p)
+	})
+}
+
+func createProductSQL(tx *sql.Tx, p *product.Product) error {
+	res, err := tx.Exec(
+		`INSERT INTO products (name, quantity, code_value, is_published, expiration, price) VALUES (?, ?, ?, ?, ?, ?)`,
+		p.Name, p.Quantity, p.CodeValue, p.Is_Published, p.Expiration, p.Price,
+	)
+	if err != nil {
+		return codeError(err, p.CodeValue)
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	p.Id = int(id)
+	return indexNameSQL(tx, p.Id, p.Name)
+}
+
+func (r *ProductSQLite) UpdateOrCreateProduct(p *product.RequestBodyProduct, id int) error {
+	return r.update(func(tx *sql.Tx) error {
+		res, err := tx.Exec(
+			`UPDATE products SET name = ?, quantity = ?, code_value = ?, is_published = ?, expiration = ?, price = ? WHERE id = ?`,
+			p.Name, p.Quantity, p.CodeValue, p.Is_Published, p.Expiration, p.Price, id,
+		)
+		if err != nil {
+			return codeError(err, p.CodeValue)
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			return indexNameSQL(tx, id, p.Name)
+		}
+
+		// like ProductSlice, missing products are created with a new id
+		return createProductSQL(tx, &product.Product{
+			Name:         p.Name,
+			Quantity:     p.Quantity,
+			CodeValue:    p.CodeValue,
+			Is_Published: p.Is_Published,
+			Expiration:   p.Expiration,
+			Price:        p.Price,
+		})
+	})
+}
+
+func (r *ProductSQLite) UpdatePartial(fields map[string]any, id int) error {
+	return r.update(func(tx *sql.Tx) error {
+		p, err := getProductSQL(tx, id)
+		if err != nil {
+			return err
+		}
+		if err := applyFields(p, fields); err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(
+			`UPDATE products SET name = ?, quantity = ?, code_value = ?, is_published = ?, expiration = ?, price = ? WHERE id = ?`,
+			p.Name, p.Quantity, p.CodeValue, p.Is_Published, p.Expiration, p.Price, id,
+		)
+		if err != nil {
+			return codeError(err, p.CodeValue)
+		}
+		return indexNameSQL(tx, id, p.Name)
+	})
+}
+
+func (r *ProductSQLite) DeleteProduct(id int) error {
+	return r.update(func(tx *sql.Tx) error {
+		res, err := tx.Exec(`DELETE FROM products WHERE id = ?`, id)
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return product.ErrProdNotFound
+		}
+		_, err = tx.Exec(`DELETE FROM product_terms WHERE product_id = ?`, id)
+		return err
+	})
+}
+
+func (r *ProductSQLite) query(query string, args ...any) ([]product.Product, error) {
+	return queryProducts(r.db, query, args...)
+}
+
+// querier is satisfied by both *sql.DB and *sql.Tx
+type querier interface {
+	Query(query string, args ...any) (*sql.Rows, error)
+}
+
+func queryProducts(q querier, query string, args ...any) ([]product.Product, error) {
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var products []product.Product
+	for rows.Next() {
+		var p product.Product
+		if err := rows.Scan(&p.Id, &p.Name, &p.Quantity, &p.CodeValue, &p.Is_Published, &p.Expiration, &p.Price); err != nil {
+			return nil, err
+		}
+		products = append(products, p)
+	}
+	return products, rows.Err()
+}
+
+// queryRower is satisfied by both *sql.DB and *sql.Tx
+type queryRower interface {
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+func getProductSQL(q queryRower, id int) (*product.Product, error) {
+	return findProductSQL(q, `id = ?`, id)
+}
+
+// findProductSQL returns the product matching condition, which must select
+// at most one
+func findProductSQL(q queryRower, condition string, arg any) (*product.Product, error) {
+	var p product.Product
+	err := q.QueryRow(`SELECT `+productColumns+` FROM products WHERE `+condition, arg).
+		Scan(&p.Id, &p.Name, &p.Quantity, &p.CodeValue, &p.Is_Published, &p.Expiration, &p.Price)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, product.ErrProdNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
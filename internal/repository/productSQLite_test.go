@@ -0,0 +1,121 @@
+package repository
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"web/clase1/internal"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newTestSQLite(t *testing.T) (*ProductSQLite, string) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "products.db")
+	rp, err := OpenProductSQLite(path)
+	require.NoError(t, err)
+	t.Cleanup(func() { rp.Close() })
+	return rp, path
+}
+
+func TestProductSQLite(t *testing.T) {
+	t.Run("should migrate once and keep data across reopens", func(t *testing.T) {
+		// Arrange
+		rp, path := newTestSQLite(t)
+		p := product.Product{Name: "Oil - Margarine", CodeValue: "S82254D", Price: 71.42}
+		require.NoError(t, rp.CreateProduct(&p))
+		require.NoError(t, rp.Close())
+		// Act
+		rp, err := OpenProductSQLite(path)
+		require.NoError(t, err)
+		defer rp.Close()
+		// Assert
+		var version int
+		require.NoError(t, rp.db.QueryRow(`SELECT MAX(version) FROM schema_migrations`).Scan(&version))
+		require.Equal(t, len(migrations), version)
+		found, err := rp.GetProductById(p.Id)
+		require.NoError(t, err)
+		require.Equal(t, p, *found)
+	})
+	t.Run("should open a path with characters reserved in URIs", func(t *testing.T) {
+		// Arrange
+		dir := filepath.Join(t.TempDir(), "a?b#c %d")
+		require.NoError(t, os.Mkdir(dir, 0755))
+		path := filepath.Join(dir, "products.db")
+		// Act
+		rp, err := OpenProductSQLite(path)
+		require.NoError(t, err)
+		defer rp.Close()
+		require.NoError(t, rp.CreateProduct(&product.Product{Name: "a", CodeValue: "A"}))
+		// Assert
+		_, err = os.Stat(path)
+		require.NoError(t, err)
+	})
+	t.Run("should reject a duplicated code_value", func(t *testing.T) {
+		// Arrange
+		rp, _ := newTestSQLite(t)
+		require.NoError(t, rp.CreateProduct(&product.Product{Name: "a", CodeValue: "S82254D"}))
+		// Act
+		err := rp.CreateProduct(&product.Product{Name: "b", CodeValue: "S82254D"})
+		// Assert
+		require.Error(t, err)
+	})
+	t.Run("should not reuse the id of a deleted product", func(t *testing.T) {
+		// Arrange
+		rp, _ := newTestSQLite(t)
+		p := product.Product{Name: "a", CodeValue: "A"}
+		require.NoError(t, rp.CreateProduct(&p))
+		require.NoError(t, rp.DeleteProduct(p.Id))
+		// Act
+		q := product.Product{Name: "b", CodeValue: "B"}
+		require.NoError(t, rp.CreateProduct(&q))
+		// Assert
+		require.Equal(t, p.Id+1, q.Id)
+		require.ErrorIs(t, rp.DeleteProduct(p.Id), product.ErrProdNotFound)
+	})
+	t.Run("should index the names stored before the search terms", func(t *testing.T) {
+		// Arrange
+		db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "products.db"))
+		require.NoError(t, err)
+		defer db.Close()
+		// the schema of a database made before product_terms
+		_, err = db.Exec(`CREATE TABLE schema_migrations (version INTEGER NOT NULL)`)
+		require.NoError(t, err)
+		for v, migration := range migrations[:2] {
+			_, err = db.Exec(migration)
+			require.NoError(t, err)
+			_, err = db.Exec(`INSERT INTO schema_migrations (version) VALUES (?)`, v+1)
+			require.NoError(t, err)
+		}
+		_, err = db.Exec(`INSERT INTO products (name, quantity, code_value, is_published, expiration, price) VALUES ('Crème Brûlée', 1, 'A', 1, '', 1)`)
+		require.NoError(t, err)
+		// Act
+		rp, err := NewProductSQLite(db)
+		require.NoError(t, err)
+		result, err := rp.SearchProducts("creme brulee", product.Page{})
+		// Assert
+		require.NoError(t, err)
+		require.Len(t, result.Products, 1)
+		require.Equal(t, "Crème Brûlée", result.Products[0].Name)
+	})
+	t.Run("should search prices through the index", func(t *testing.T) {
+		// Arrange
+		rp, _ := newTestSQLite(t)
+		for i, price := range []float64{10, 20, 30} {
+			require.NoError(t, rp.CreateProduct(&product.Product{CodeValue: string(rune('A' + i)), Price: price}))
+		}
+		// Act
+		products := rp.FindProductsByPriceGt(15)
+		// Assert
+		require.Len(t, products, 2)
+
+		var id, parent, notused int
+		var detail string
+		require.NoError(t, rp.db.QueryRow(`EXPLAIN QUERY PLAN `+queryPriceGt, 15.0).
+			Scan(&id, &parent, &notused, &detail))
+		require.True(t, strings.Contains(detail, "products_price"), detail)
+	})
+}
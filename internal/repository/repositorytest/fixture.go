@@ -0,0 +1,66 @@
+package repositorytest
+
+import (
+	"encoding/json"
+	"fmt"
+	"web/clase1/internal"
+	"web/clase1/internal/storage"
+)
+
+// Fixture builds a product catalog for tests
+//
+//	st := repositorytest.NewFixture().WithN(3).With(product.Product{Name: "Oil"}).Storage()
+type Fixture struct {
+	products []product.Product
+	lastId   int
+}
+
+func NewFixture() *Fixture {
+	return &Fixture{}
+}
+
+// With adds products to the catalog, those without an id get the next one
+func (f *Fixture) With(products ...product.Product) *Fixture {
+	for _, p := range products {
+		if p.Id == 0 {
+			p.Id = f.lastId + 1
+		}
+		if p.Id > f.lastId {
+			f.lastId = p.Id
+		}
+		f.products = append(f.products, p)
+	}
+	return f
+}
+
+// WithN adds n generated products with unique code values
+func (f *Fixture) WithN(n int) *Fixture {
+	for i := 0; i < n; i++ {
+		id := f.lastId + 1
+		f.With(product.Product{
+			Id:           id,
+			Name:         fmt.Sprintf("Product %d", id),
+			Quantity:     id,
+			CodeValue:    fmt.Sprintf("C%d", id),
+			Is_Published: true,
+			Expiration:   "15/12/2021",
+			Price:        float64(id),
+		})
+	}
+	return f
+}
+
+// Products returns a copy of the catalog
+func (f *Fixture) Products() []product.Product {
+	return append([]product.Product{}, f.products...)
+}
+
+// Storage returns a new in-memory storage holding the catalog
+func (f *Fixture) Storage() *storage.StorageMemory {
+	data, err := json.Marshal(f.Products())
+	if err != nil {
+		// products always marshal
+		panic(err)
+	}
+	return storage.NewStorageMemory(data)
+}
@@ -0,0 +1,672 @@
+// Package repositorytest is a conformance suite for product.ProductRepository
+// implementations. A backend plugs in by providing a Factory:
+//
+//	func TestConformance(t *testing.T) {
+//		repositorytest.Run(t, func(t *testing.T, seed []product.Product) (product.ProductRepository, func() product.ProductRepository) {
+//			...
+//		})
+//	}
+package repositorytest
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+	"web/clase1/internal"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Factory opens a repository holding seed, with the ids given. reopen closes
+// it and opens it again over the same data, as a restart would; backends
+// that don't persist return a nil reopen and skip those cases.
+type Factory func(t *testing.T, seed []product.Product) (rp product.ProductRepository, reopen func() product.ProductRepository)
+
+// Products returns n products with ids 1 to n and unique code values
+func Products(n int) []product.Product {
+	return NewFixture().WithN(n).Products()
+}
+
+// Run runs every conformance case against the repositories made by open
+func Run(t *testing.T, open Factory) {
+	t.Run("GetAllProducts", func(t *testing.T) { testGetAllProducts(t, open) })
+	t.Run("GetProductById", func(t *testing.T) { testGetProductById(t, open) })
+	t.Run("GetProductByCode", func(t *testing.T) { testGetProductByCode(t, open) })
+	t.Run("FindProductsByPriceGt", func(t *testing.T) { testFindProductsByPriceGt(t, open) })
+	t.Run("FindProducts", func(t *testing.T) { testFindProducts(t, open) })
+	t.Run("FindProductsPage", func(t *testing.T) { testFindProductsPage(t, open) })
+	t.Run("FindProductsSorted", func(t *testing.T) { testFindProductsSorted(t, open) })
+	t.Run("SearchProducts", func(t *testing.T) { testSearchProducts(t, open) })
+	t.Run("CreateProduct", func(t *testing.T) { testCreateProduct(t, open) })
+	t.Run("UpdateOrCreateProduct", func(t *testing.T) { testUpdateOrCreateProduct(t, open) })
+	t.Run("UpdatePartial", func(t *testing.T) { testUpdatePartial(t, open) })
+	t.Run("DeleteProduct", func(t *testing.T) { testDeleteProduct(t, open) })
+	t.Run("Ids", func(t *testing.T) { testIds(t, open) })
+	t.Run("UniqueCodes", func(t *testing.T) { testUniqueCodes(t, open) })
+	t.Run("Concurrency", func(t *testing.T) { testConcurrency(t, open) })
+}
+
+func testGetAllProducts(t *testing.T, open Factory) {
+	t.Run("should return every product in id order", func(t *testing.T) {
+		rp, _ := open(t, Products(3))
+
+		products, err := rp.GetAllProducts()
+
+		require.NoError(t, err)
+		require.Equal(t, Products(3), products)
+	})
+	t.Run("should fail when there are no products", func(t *testing.T) {
+		rp, _ := open(t, nil)
+
+		_, err := rp.GetAllProducts()
+
+		require.Error(t, err)
+	})
+}
+
+func testGetProductById(t *testing.T, open Factory) {
+	t.Run("should return the product", func(t *testing.T) {
+		rp, _ := open(t, Products(3))
+
+		p, err := rp.GetProductById(2)
+
+		require.NoError(t, err)
+		require.Equal(t, Products(3)[1], *p)
+	})
+	t.Run("should return ErrProdNotFound for a missing id", func(t *testing.T) {
+		rp, _ := open(t, Products(3))
+
+		_, err := rp.GetProductById(4)
+
+		require.ErrorIs(t, err, product.ErrProdNotFound)
+	})
+}
+
+func testGetProductByCode(t *testing.T, open Factory) {
+	t.Run("should return the product", func(t *testing.T) {
+		rp, _ := open(t, Products(3))
+
+		p, err := rp.GetProductByCode("C2")
+
+		require.NoError(t, err)
+		require.Equal(t, Products(3)[1], *p)
+	})
+	t.Run("should return ErrProdNotFound for a missing code", func(t *testing.T) {
+		rp, _ := open(t, Products(3))
+
+		_, err := rp.GetProductByCode("C4")
+
+		require.ErrorIs(t, err, product.ErrProdNotFound)
+	})
+	t.Run("should follow changes of code", func(t *testing.T) {
+		rp, _ := open(t, Products(3))
+
+		require.NoError(t, rp.UpdatePartial(map[string]any{"code_value": "NEW"}, 2))
+
+		_, err := rp.GetProductByCode("C2")
+		require.ErrorIs(t, err, product.ErrProdNotFound)
+		p, err := rp.GetProductByCode("NEW")
+		require.NoError(t, err)
+		require.Equal(t, 2, p.Id)
+	})
+}
+
+func testFindProductsByPriceGt(t *testing.T, open Factory) {
+	t.Run("should return the products strictly above the price", func(t *testing.T) {
+		rp, _ := open(t, Products(5))
+
+		products := rp.FindProductsByPriceGt(3)
+
+		require.Equal(t, Products(5)[3:], products)
+	})
+	t.Run("should return nothing when no price is above", func(t *testing.T) {
+		rp, _ := open(t, Products(5))
+
+		require.Empty(t, rp.FindProductsByPriceGt(5))
+	})
+}
+
+func testFindProducts(t *testing.T, open Factory) {
+	seed := Products(6)
+	seed[0].Expiration = "01/01/2020"
+	seed[1].Is_Published = false
+	seed[2].Expiration = "soon"
+	seed[4].Is_Published = false
+	seed[5].Expiration = "31/12/2030"
+	ptr := func(v float64) *float64 { return &v }
+	qty := func(v int) *int { return &v }
+	published := true
+	date := func(s string) *time.Time {
+		d, err := time.Parse(time.DateOnly, s)
+		require.NoError(t, err)
+		return &d
+	}
+
+	cases := []struct {
+		name   string
+		filter product.Filter
+		ids    []int
+	}{
+		{"should return every product for the zero filter", product.Filter{}, []int{1, 2, 3, 4, 5, 6}},
+		{"should combine a price range and the published flag", product.Filter{PriceGte: ptr(2), PriceLte: ptr(5), IsPublished: &published}, []int{3, 4}},
+		{"should match names ignoring case", product.Filter{QuantityLte: qty(2), NameContains: "PRODUCT"}, []int{1, 2}},
+		{"should match code value prefixes and skip unparsable expirations", product.Filter{CodePrefix: "C", ExpirationAfter: date("2021-12-15")}, []int{6}},
+		{"should keep expiration bounds exclusive", product.Filter{ExpirationBefore: date("2021-12-15")}, []int{1}},
+		{"should return an empty list when nothing matches", product.Filter{QuantityGte: qty(7)}, []int{}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			rp, _ := open(t, seed)
+
+			page, err := rp.FindProducts(c.filter, product.Page{})
+
+			require.NoError(t, err)
+			require.Equal(t, c.ids, ids(page.Products))
+			require.Equal(t, len(c.ids), page.Total)
+		})
+	}
+}
+
+func testFindProductsPage(t *testing.T, open Factory) {
+	// the even products are unpublished
+	seed := Products(10)
+	for i := 1; i < len(seed); i += 2 {
+		seed[i].Is_Published = false
+	}
+	unpublished := product.Filter{IsPublished: new(bool)}
+
+	cases := []struct {
+		name    string
+		filter  product.Filter
+		page    product.Page
+		ids     []int
+		total   int
+		hasPrev bool
+		hasNext bool
+	}{
+		{"should return a window by offset", product.Filter{}, product.Page{Limit: 3, Offset: 3}, []int{4, 5, 6}, 10, true, true},
+		{"should return the first window", product.Filter{}, product.Page{Limit: 3}, []int{1, 2, 3}, 10, false, true},
+		{"should return the rest of the listing in the last window", product.Filter{}, product.Page{Limit: 3, Offset: 9}, []int{10}, 10, true, false},
+		{"should return nothing past the end", product.Filter{}, product.Page{Limit: 3, Offset: 20}, []int{}, 10, true, false},
+		{"should page the filtered products", unpublished, product.Page{Limit: 2, Offset: 2}, []int{6, 8}, 5, true, true},
+		{"should continue after a cursor", unpublished, product.Page{Limit: 2, Cursor: &product.Cursor{Key: product.Product{Id: 5}}}, []int{6, 8}, 5, true, true},
+		{"should go back before a cursor", unpublished, product.Page{Limit: 2, Cursor: &product.Cursor{Key: product.Product{Id: 6}, Backward: true}}, []int{2, 4}, 5, false, true},
+		{"should return the last window after a cursor", product.Filter{}, product.Page{Limit: 5, Cursor: &product.Cursor{Key: product.Product{Id: 7}}}, []int{8, 9, 10}, 10, true, false},
+		{"should return every product after a cursor without a limit", product.Filter{}, product.Page{Cursor: &product.Cursor{Key: product.Product{Id: 8}}}, []int{9, 10}, 10, true, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			rp, _ := open(t, seed)
+
+			page, err := rp.FindProducts(c.filter, c.page)
+
+			require.NoError(t, err)
+			require.Equal(t, c.ids, ids(page.Products))
+			require.Equal(t, c.total, page.Total)
+			require.Equal(t, c.hasPrev, page.HasPrev, "HasPrev")
+			require.Equal(t, c.hasNext, page.HasNext, "HasNext")
+		})
+	}
+}
+
+func testFindProductsSorted(t *testing.T, open Factory) {
+	seed := Products(6)
+	for i, p := range []struct {
+		name       string
+		price      float64
+		expiration string
+	}{
+		{"b", 5, "31/12/2030"},
+		{"a", 3, "01/01/2020"},
+		{"a", 5, "soon"},
+		{"c", 1, "15/06/2025"},
+		{"b", 3, "15/12/2021"},
+		{"a", 5, "01/01/2020"},
+	} {
+		seed[i].Name, seed[i].Price, seed[i].Expiration = p.name, p.price, p.expiration
+	}
+	seed[1].Is_Published = false
+	seed[4].Is_Published = false
+	byPriceDescName := []product.SortField{{Field: "price", Desc: true}, {Field: "name"}}
+
+	cases := []struct {
+		name    string
+		filter  product.Filter
+		page    product.Page
+		ids     []int
+		hasPrev bool
+		hasNext bool
+	}{
+		{"should sort by several fields with id as the tiebreaker", product.Filter{}, product.Page{Sort: byPriceDescName}, []int{3, 6, 1, 2, 5, 4}, false, false},
+		{"should keep id order between equal values", product.Filter{}, product.Page{Sort: []product.SortField{{Field: "price"}}}, []int{4, 2, 5, 1, 3, 6}, false, false},
+		{"should sort expirations as dates, unparsable ones first", product.Filter{}, product.Page{Sort: []product.SortField{{Field: "expiration"}}}, []int{3, 2, 6, 5, 4, 1}, false, false},
+		{"should reverse the order of a descending field only", product.Filter{}, product.Page{Sort: []product.SortField{{Field: "expiration", Desc: true}}}, []int{1, 4, 5, 2, 6, 3}, false, false},
+		{"should sort by descending id", product.Filter{}, product.Page{Sort: []product.SortField{{Field: "id", Desc: true}}}, []int{6, 5, 4, 3, 2, 1}, false, false},
+		{"should sort the filtered products", product.Filter{IsPublished: new(bool)}, product.Page{Sort: []product.SortField{{Field: "name", Desc: true}}}, []int{5, 2}, false, false},
+		{"should page the sorted listing by offset", product.Filter{}, product.Page{Limit: 2, Offset: 2, Sort: byPriceDescName}, []int{1, 2}, true, true},
+		{"should continue after a cursor in the sorted listing", product.Filter{}, product.Page{Limit: 3, Sort: byPriceDescName, Cursor: &product.Cursor{Key: seed[5]}}, []int{1, 2, 5}, true, true},
+		{"should go back before a cursor in the sorted listing", product.Filter{}, product.Page{Limit: 2, Sort: byPriceDescName, Cursor: &product.Cursor{Key: seed[1], Backward: true}}, []int{6, 1}, true, true},
+		{"should keep the key of an inclusive cursor", product.Filter{}, product.Page{Limit: 2, Sort: byPriceDescName, Cursor: &product.Cursor{Key: seed[1], Inclusive: true}}, []int{2, 5}, true, true},
+		{"should continue in place from a product no longer there", product.Filter{}, product.Page{Sort: byPriceDescName, Cursor: &product.Cursor{Key: product.Product{Id: 7, Name: "a", Price: 3}}}, []int{5, 4}, true, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			rp, _ := open(t, seed)
+
+			page, err := rp.FindProducts(c.filter, c.page)
+
+			require.NoError(t, err)
+			require.Equal(t, c.ids, ids(page.Products))
+			require.Equal(t, c.hasPrev, page.HasPrev, "HasPrev")
+			require.Equal(t, c.hasNext, page.HasNext, "HasNext")
+		})
+	}
+}
+
+func testSearchProducts(t *testing.T, open Factory) {
+	seed := Products(6)
+	for i, name := range []string{"Crème Brûlée", "Pineapple - Canned, Rings", "Apple Juice", "Apple Pie", "Oil - Margarine", "Canned Pineapple Chunks"} {
+		seed[i].Name = name
+	}
+
+	cases := []struct {
+		name  string
+		query string
+		limit int
+		ids   []int
+	}{
+		{"should ignore case and accents", "CREME brulee", 0, []int{1}},
+		{"should ignore accents written as combining marks", "cre\u0300me", 0, []int{1}},
+		{"should match short words by prefix", "app", 0, []int{3, 4}},
+		{"should match whole words only when equal or a prefix", "apple", 0, []int{3, 4}},
+		{"should rank prefixes above misspellings", "pine", 0, []int{2, 6, 4}},
+		{"should match misspelled words", "pineaple", 0, []int{2, 6}},
+		{"should match the misspelled start of a word", "margarni", 0, []int{5}},
+		{"should rank products matching more words first", "pineapple chunks", 0, []int{6, 2}},
+		{"should return the most relevant products up to the limit", "canned pineapple rings", 1, []int{2}},
+		{"should return nothing for unknown words", "xyz", 0, []int{}},
+		{"should return nothing without words to search", " - ", 0, []int{}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			rp, _ := open(t, seed)
+
+			result, err := rp.SearchProducts(c.query, product.Page{Limit: c.limit})
+
+			require.NoError(t, err)
+			require.Equal(t, c.ids, ids(result.Products))
+		})
+	}
+	t.Run("should return whole products", func(t *testing.T) {
+		rp, _ := open(t, seed)
+
+		result, err := rp.SearchProducts("juice", product.Page{})
+
+		require.NoError(t, err)
+		require.Equal(t, seed[2:3], result.Products)
+	})
+	t.Run("should keep the index up to date with changes", func(t *testing.T) {
+		rp, _ := open(t, seed)
+		p := product.Product{Name: "Apple Cider", CodeValue: "NEW"}
+
+		require.NoError(t, rp.CreateProduct(&p))
+		require.NoError(t, rp.UpdatePartial(map[string]any{"name": "Pear Juice"}, 3))
+		require.NoError(t, rp.DeleteProduct(4))
+
+		apples, err := rp.SearchProducts("apple", product.Page{})
+		require.NoError(t, err)
+		require.Equal(t, []int{p.Id}, ids(apples.Products))
+		pears, err := rp.SearchProducts("pear", product.Page{})
+		require.NoError(t, err)
+		require.Equal(t, []int{3}, ids(pears.Products))
+	})
+	t.Run("should keep the index across restarts", func(t *testing.T) {
+		rp, reopen := open(t, seed)
+		if reopen == nil {
+			t.Skip("backend doesn't persist")
+		}
+		body := product.RequestBodyProduct{Name: "Mango Juice", CodeValue: "M"}
+		require.NoError(t, rp.UpdateOrCreateProduct(&body, 3))
+
+		result, err := reopen().SearchProducts("mango", product.Page{})
+
+		require.NoError(t, err)
+		require.Equal(t, []int{3}, ids(result.Products))
+	})
+	t.Run("should page the ranked products by offset", func(t *testing.T) {
+		rp, _ := open(t, seed)
+
+		result, err := rp.SearchProducts("pine", product.Page{Limit: 2, Offset: 1})
+
+		require.NoError(t, err)
+		require.Equal(t, []int{6, 4}, ids(result.Products))
+		require.Equal(t, 3, result.Total)
+		require.True(t, result.HasPrev)
+		require.False(t, result.HasNext)
+		require.Len(t, result.Scores, 2)
+		require.Greater(t, result.Scores[0], result.Scores[1])
+	})
+	t.Run("should page the ranked products by cursor both ways", func(t *testing.T) {
+		rp, _ := open(t, seed)
+		first, err := rp.SearchProducts("pine", product.Page{Limit: 1})
+		require.NoError(t, err)
+		after := func(result product.ProductPage, i int, backward bool) *product.Cursor {
+			return &product.Cursor{Key: result.Products[i], Score: result.Scores[i], Backward: backward}
+		}
+
+		second, err := rp.SearchProducts("pine", product.Page{Limit: 1, Cursor: after(first, 0, false)})
+		require.NoError(t, err)
+		third, err := rp.SearchProducts("pine", product.Page{Limit: 2, Cursor: after(second, 0, false)})
+		require.NoError(t, err)
+		back, err := rp.SearchProducts("pine", product.Page{Limit: 2, Cursor: after(third, 0, true)})
+		require.NoError(t, err)
+
+		require.Equal(t, []int{2}, ids(first.Products))
+		require.Equal(t, []int{6}, ids(second.Products))
+		require.True(t, second.HasPrev)
+		require.True(t, second.HasNext)
+		require.Equal(t, []int{4}, ids(third.Products))
+		require.False(t, third.HasNext)
+		require.Equal(t, []int{2, 6}, ids(back.Products))
+		require.False(t, back.HasPrev)
+		require.True(t, back.HasNext)
+	})
+}
+
+func testUniqueCodes(t *testing.T, open Factory) {
+	t.Run("should reject creating a product with a used code", func(t *testing.T) {
+		rp, _ := open(t, Products(3))
+		p := product.Product{Name: "new", CodeValue: "C2"}
+
+		err := rp.CreateProduct(&p)
+
+		require.ErrorIs(t, err, product.ErrProdDuplicateCode)
+		require.Zero(t, p.Id)
+		products, err := rp.GetAllProducts()
+		require.NoError(t, err)
+		require.Equal(t, Products(3), products)
+		// the rejected product took no id
+		p.CodeValue = "C4"
+		require.NoError(t, rp.CreateProduct(&p))
+		require.Equal(t, 4, p.Id)
+	})
+	t.Run("should reject replacing a product with the code of another", func(t *testing.T) {
+		rp, _ := open(t, Products(3))
+		body := product.RequestBodyProduct{Name: "replaced", CodeValue: "C2"}
+
+		require.ErrorIs(t, rp.UpdateOrCreateProduct(&body, 1), product.ErrProdDuplicateCode)
+		require.ErrorIs(t, rp.UpdateOrCreateProduct(&body, 42), product.ErrProdDuplicateCode)
+		require.NoError(t, rp.UpdateOrCreateProduct(&body, 2))
+
+		products, err := rp.GetAllProducts()
+		require.NoError(t, err)
+		require.Len(t, products, 3)
+		require.Equal(t, Products(3)[0], products[0])
+	})
+	t.Run("should reject changing the code to the one of another product", func(t *testing.T) {
+		rp, _ := open(t, Products(3))
+
+		err := rp.UpdatePartial(map[string]any{"code_value": "C3"}, 1)
+
+		require.ErrorIs(t, err, product.ErrProdDuplicateCode)
+		p, err := rp.GetProductById(1)
+		require.NoError(t, err)
+		require.Equal(t, Products(3)[0], *p)
+	})
+	t.Run("should free the code of a deleted product", func(t *testing.T) {
+		rp, _ := open(t, Products(3))
+		require.NoError(t, rp.DeleteProduct(2))
+
+		p := product.Product{Name: "new", CodeValue: "C2"}
+		require.NoError(t, rp.CreateProduct(&p))
+
+		found, err := rp.GetProductByCode("C2")
+		require.NoError(t, err)
+		require.Equal(t, p, *found)
+	})
+}
+
+// ids returns the ids of products, never nil
+func ids(products []product.Product) []int {
+	ids := []int{}
+	for _, p := range products {
+		ids = append(ids, p.Id)
+	}
+	return ids
+}
+
+func testCreateProduct(t *testing.T, open Factory) {
+	t.Run("should assign the next id and store the product", func(t *testing.T) {
+		rp, _ := open(t, Products(3))
+		p := product.Product{Name: "new", Quantity: 1, CodeValue: "NEW", Is_Published: true, Expiration: "01/01/2030", Price: 10}
+
+		require.NoError(t, rp.CreateProduct(&p))
+
+		require.Equal(t, 4, p.Id)
+		found, err := rp.GetProductById(4)
+		require.NoError(t, err)
+		require.Equal(t, p, *found)
+	})
+	t.Run("should start ids at 1 in an empty repository", func(t *testing.T) {
+		rp, _ := open(t, nil)
+		p := product.Product{Name: "new", CodeValue: "NEW"}
+
+		require.NoError(t, rp.CreateProduct(&p))
+
+		require.Equal(t, 1, p.Id)
+	})
+}
+
+func testUpdateOrCreateProduct(t *testing.T, open Factory) {
+	t.Run("should replace every field of an existing product", func(t *testing.T) {
+		rp, _ := open(t, Products(3))
+		body := product.RequestBodyProduct{Name: "replaced", Quantity: 7, CodeValue: "R", Is_Published: false, Expiration: "01/01/2030", Price: 9.5}
+
+		require.NoError(t, rp.UpdateOrCreateProduct(&body, 2))
+
+		p, err := rp.GetProductById(2)
+		require.NoError(t, err)
+		require.Equal(t, product.Product{Id: 2, Name: "replaced", Quantity: 7, CodeValue: "R", Expiration: "01/01/2030", Price: 9.5}, *p)
+	})
+	t.Run("should create a missing product with a new id", func(t *testing.T) {
+		rp, _ := open(t, Products(3))
+		body := product.RequestBodyProduct{Name: "created", CodeValue: "NEW"}
+
+		require.NoError(t, rp.UpdateOrCreateProduct(&body, 42))
+
+		products, err := rp.GetAllProducts()
+		require.NoError(t, err)
+		require.Len(t, products, 4)
+		require.Equal(t, 4, products[3].Id)
+		require.Equal(t, "created", products[3].Name)
+	})
+}
+
+func testUpdatePartial(t *testing.T, open Factory) {
+	t.Run("should only change the given fields", func(t *testing.T) {
+		rp, _ := open(t, Products(3))
+
+		// numbers come from decoded JSON as float64
+		err := rp.UpdatePartial(map[string]any{"name": "renamed", "quantity": float64(99), "price": 1.5}, 2)
+
+		require.NoError(t, err)
+		p, err := rp.GetProductById(2)
+		require.NoError(t, err)
+		expected := Products(3)[1]
+		expected.Name, expected.Quantity, expected.Price = "renamed", 99, 1.5
+		require.Equal(t, expected, *p)
+	})
+	t.Run("should return ErrProdInvalidField for unknown fields or wrong types", func(t *testing.T) {
+		rp, _ := open(t, Products(3))
+
+		require.ErrorIs(t, rp.UpdatePartial(map[string]any{"color": "red"}, 2), product.ErrProdInvalidField)
+		require.ErrorIs(t, rp.UpdatePartial(map[string]any{"quantity": "many"}, 2), product.ErrProdInvalidField)
+		require.ErrorIs(t, rp.UpdatePartial(map[string]any{"quantity": 1.5}, 2), product.ErrProdInvalidField)
+
+		p, err := rp.GetProductById(2)
+		require.NoError(t, err)
+		require.Equal(t, Products(3)[1], *p)
+	})
+	t.Run("should return ErrProdNotFound for a missing id", func(t *testing.T) {
+		rp, _ := open(t, Products(3))
+
+		err := rp.UpdatePartial(map[string]any{"name": "renamed"}, 4)
+
+		require.ErrorIs(t, err, product.ErrProdNotFound)
+	})
+}
+
+func testDeleteProduct(t *testing.T, open Factory) {
+	t.Run("should remove the product", func(t *testing.T) {
+		rp, _ := open(t, Products(3))
+
+		require.NoError(t, rp.DeleteProduct(2))
+
+		_, err := rp.GetProductById(2)
+		require.ErrorIs(t, err, product.ErrProdNotFound)
+		products, err := rp.GetAllProducts()
+		require.NoError(t, err)
+		require.Equal(t, []product.Product{Products(3)[0], Products(3)[2]}, products)
+	})
+	t.Run("should return ErrProdNotFound for a missing id", func(t *testing.T) {
+		rp, _ := open(t, Products(3))
+
+		require.ErrorIs(t, rp.DeleteProduct(4), product.ErrProdNotFound)
+	})
+}
+
+func testIds(t *testing.T, open Factory) {
+	t.Run("should not reuse the id of a deleted product", func(t *testing.T) {
+		rp, _ := open(t, Products(3))
+		require.NoError(t, rp.DeleteProduct(3))
+
+		p := product.Product{Name: "new", CodeValue: "N"}
+		require.NoError(t, rp.CreateProduct(&p))
+
+		require.Equal(t, 4, p.Id)
+	})
+	t.Run("should keep the sequence across restarts", func(t *testing.T) {
+		rp, reopen := open(t, Products(3))
+		if reopen == nil {
+			t.Skip("backend doesn't persist")
+		}
+		p := product.Product{Name: "new", CodeValue: "N"}
+		require.NoError(t, rp.CreateProduct(&p))
+		require.NoError(t, rp.DeleteProduct(p.Id))
+
+		p = product.Product{Name: "newer", CodeValue: "NN"}
+		require.NoError(t, reopen().CreateProduct(&p))
+
+		require.Equal(t, 5, p.Id)
+	})
+	t.Run("should update products whose id doesn't match their position", func(t *testing.T) {
+		rp, _ := open(t, Products(3))
+		require.NoError(t, rp.DeleteProduct(1))
+
+		require.NoError(t, rp.UpdatePartial(map[string]any{"name": "renamed"}, 3))
+		require.NoError(t, rp.UpdateOrCreateProduct(&product.RequestBodyProduct{Name: "replaced", CodeValue: "R"}, 2))
+
+		p, err := rp.GetProductById(3)
+		require.NoError(t, err)
+		require.Equal(t, "renamed", p.Name)
+		p, err = rp.GetProductById(2)
+		require.NoError(t, err)
+		require.Equal(t, "replaced", p.Name)
+	})
+	t.Run("should keep ids unique", func(t *testing.T) {
+		rp, _ := open(t, Products(3))
+		for i := 0; i < 5; i++ {
+			p := product.Product{Name: "new", CodeValue: fmt.Sprintf("N%d", i)}
+			require.NoError(t, rp.CreateProduct(&p))
+		}
+		require.NoError(t, rp.DeleteProduct(5))
+
+		products, err := rp.GetAllProducts()
+		require.NoError(t, err)
+		seen := make(map[int]bool)
+		for _, p := range products {
+			require.False(t, seen[p.Id], "duplicated id %d", p.Id)
+			seen[p.Id] = true
+		}
+	})
+}
+
+func testConcurrency(t *testing.T, open Factory) {
+	t.Run("should stay consistent under concurrent use", func(t *testing.T) {
+		const seeded, workers, rounds = 10, 8, 25
+		rp, reopen := open(t, Products(seeded))
+
+		// require stops the goroutine calling it, which must be the test's,
+		// so workers report their first error once they're done
+		round := func(w, i int) error {
+			id := (w+i)%seeded + 1
+
+			if _, err := rp.GetAllProducts(); err != nil {
+				return err
+			}
+			if _, err := rp.GetProductById(id); err != nil {
+				return err
+			}
+			rp.FindProductsByPriceGt(float64(i))
+			if _, err := rp.GetProductByCode(fmt.Sprintf("C%d", id)); err != nil {
+				return err
+			}
+			price := float64(i)
+			page := product.Page{Limit: 3, Sort: []product.SortField{{Field: "price", Desc: true}}}
+			if _, err := rp.FindProducts(product.Filter{PriceGt: &price}, page); err != nil {
+				return err
+			}
+			if _, err := rp.SearchProducts("product", product.Page{Limit: 3}); err != nil {
+				return err
+			}
+
+			if err := rp.UpdatePartial(map[string]any{"price": float64(w * i)}, id); err != nil {
+				return err
+			}
+			err := rp.UpdateOrCreateProduct(&product.RequestBodyProduct{
+				Name:      fmt.Sprintf("Product %d", id),
+				CodeValue: fmt.Sprintf("C%d", id),
+				Price:     float64(i),
+			}, id)
+			if err != nil {
+				return err
+			}
+
+			p := product.Product{Name: "temp", CodeValue: fmt.Sprintf("T%d-%d", w, i)}
+			if err := rp.CreateProduct(&p); err != nil {
+				return err
+			}
+			return rp.DeleteProduct(p.Id)
+		}
+
+		errs := make(chan error, workers)
+		var wg sync.WaitGroup
+		for w := 0; w < workers; w++ {
+			wg.Add(1)
+			go func(w int) {
+				defer wg.Done()
+				for i := 0; i < rounds; i++ {
+					if err := round(w, i); err != nil {
+						errs <- fmt.Errorf("worker %d, round %d: %w", w, i, err)
+						return
+					}
+				}
+			}(w)
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			require.NoError(t, err)
+		}
+
+		products, err := rp.GetAllProducts()
+		require.NoError(t, err)
+		require.Len(t, products, seeded)
+		if reopen != nil {
+			stored, err := reopen().GetAllProducts()
+			require.NoError(t, err)
+			require.Equal(t, products, stored)
+		}
+	})
+}
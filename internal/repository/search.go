@@ -0,0 +1,338 @@
+package repository
+
+import (
+	"cmp"
+	"slices"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+	"web/clase1/internal"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// folds maps the letters that don't decompose into a base letter and its
+// accents to the ones they are searched by
+var folds = map[rune]string{}
+
+func init() {
+	for base, accented := range map[string]string{
+		"d": "đ", "h": "ħ", "i": "ı", "l": "ŀł", "n": "ŉ", "o": "ø", "t": "ŧ",
+		"ae": "æ", "oe": "œ", "ss": "ß", "th": "þ",
+	} {
+		for _, r := range accented {
+			folds[r] = base
+		}
+	}
+}
+
+// searchTerms splits s into the terms names are indexed and searched by:
+// lowercase runs of letters and digits without accents, each once. Names
+// are decomposed first, so accents are dropped whether they were written
+// precomposed or as combining marks.
+func searchTerms(s string) []string {
+	var terms []string
+	var b strings.Builder
+	flush := func() {
+		if b.Len() > 0 && !slices.Contains(terms, b.String()) {
+			terms = append(terms, b.String())
+		}
+		b.Reset()
+	}
+	for _, r := range norm.NFD.String(strings.ToLower(s)) {
+		switch {
+		case unicode.Is(unicode.Mn, r):
+			// an accent of the previous letter
+		case folds[r] != "":
+			b.WriteString(folds[r])
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			b.WriteRune(r)
+		default:
+			flush()
+		}
+	}
+	flush()
+	return terms
+}
+
+// scanTerms calls fn in order with the terms of an inverted index from
+// from up to to, excluded, or up to the last one when to is empty, and the
+// ids of the products named with each
+type scanTerms func(from, to string, fn func(term string, ids []int)) error
+
+// prefixEnd is the first string after all the ones starting with prefix.
+// UTF-8 never holds a 0xff byte, so the last byte can always be increased.
+func prefixEnd(prefix string) string {
+	end := []byte(prefix)
+	end[len(end)-1]++
+	return string(end)
+}
+
+// match is a product found by a search and its score
+type match struct {
+	id    int
+	score float64
+}
+
+// compareMatches orders matches the most relevant first and by id among
+// equals
+func compareMatches(a, b match) int {
+	if c := cmp.Compare(b.score, a.score); c != 0 {
+		return c
+	}
+	return cmp.Compare(a.id, b.id)
+}
+
+// rankNames returns the products whose names match query, ordered by
+// compareMatches. Each query term scores the best match of a product's
+// terms, see matchTerm, and a product scores the sum of its query terms.
+// Terms that must be spelled right are sought by prefix, the index is only
+// read whole for the ones that may be misspelled.
+func rankNames(query string, scan scanTerms) ([]match, error) {
+	queryTerms := searchTerms(query)
+	if len(queryTerms) == 0 {
+		return []match{}, nil
+	}
+
+	best := make(map[int][]float64)
+	score := func(i int, term string, ids []int) {
+		score := matchTerm(queryTerms[i], term)
+		if score == 0 {
+			return
+		}
+		for _, id := range ids {
+			if best[id] == nil {
+				best[id] = make([]float64, len(queryTerms))
+			}
+			best[id][i] = max(best[id][i], score)
+		}
+	}
+
+	var misspelled []int
+	for i, q := range queryTerms {
+		if maxEdits(utf8.RuneCountInString(q)) > 0 {
+			misspelled = append(misspelled, i)
+			continue
+		}
+		err := scan(q, prefixEnd(q), func(term string, ids []int) {
+			score(i, term, ids)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(misspelled) > 0 {
+		err := scan("", "", func(term string, ids []int) {
+			for _, i := range misspelled {
+				score(i, term, ids)
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	matches := make([]match, 0, len(best))
+	for id, termScores := range best {
+		m := match{id: id}
+		for _, score := range termScores {
+			m.score += score
+		}
+		matches = append(matches, m)
+	}
+	slices.SortFunc(matches, compareMatches)
+	return matches, nil
+}
+
+// searchPage ranks the names scan finds for query and returns the window
+// of them selected by page, reading its products with get. A cursor
+// continues from the score and id of its Key.
+func searchPage(query string, page product.Page, scan scanTerms, get func(id int) (product.Product, error)) (product.ProductPage, error) {
+	matches, err := rankNames(query, scan)
+	if err != nil {
+		return product.ProductPage{}, err
+	}
+
+	start, end, before := 0, len(matches), 0
+	switch cursor := page.Cursor; {
+	case cursor == nil:
+		start = min(page.Offset, len(matches))
+		if page.Limit > 0 {
+			end = min(start+page.Limit, len(matches))
+		}
+	default:
+		key := match{id: cursor.Key.Id, score: cursor.Score}
+		// matches are ordered, the ones a cursor passes are all on its side
+		before = sort.Search(len(matches), func(i int) bool {
+			c := compareMatches(matches[i], key)
+			if cursor.Backward {
+				return c > 0 || !cursor.Inclusive && c == 0
+			}
+			return c > 0 || cursor.Inclusive && c == 0
+		})
+		if cursor.Backward {
+			start, end = 0, before
+			if page.Limit > 0 {
+				start = max(0, end-page.Limit)
+			}
+		} else {
+			start = before
+			if page.Limit > 0 {
+				end = min(start+page.Limit, len(matches))
+			}
+		}
+	}
+
+	window := matches[start:end]
+	products := make([]product.Product, 0, len(window))
+	scores := make([]float64, 0, len(window))
+	for _, m := range window {
+		p, err := get(m.id)
+		if err != nil {
+			return product.ProductPage{}, err
+		}
+		products = append(products, p)
+		scores = append(scores, m.score)
+	}
+	result := pageOf(page, products, len(matches), before)
+	result.Scores = scores
+	return result, nil
+}
+
+// matchTerm scores how well an indexed term matches a query term, zero
+// when it doesn't: 1 when equal, 0.75 when the term starts with it, and
+// for misspellings 0.5 or 0.4 divided by the edits to the whole term or to
+// its start
+func matchTerm(query, term string) float64 {
+	if term == query {
+		return 1
+	}
+	if strings.HasPrefix(term, query) {
+		return 0.75
+	}
+
+	q, t := []rune(query), []rune(term)
+	edits := maxEdits(len(q))
+	if edits == 0 {
+		return 0
+	}
+	// the distance is at least the difference of the lengths
+	if abs(len(t)-len(q)) <= edits {
+		if d := editDistance(q, t, edits); d <= edits {
+			return 0.5 / float64(d)
+		}
+	}
+	if len(t) > len(q) {
+		if d := editDistance(q, t[:len(q)], edits); d <= edits {
+			return 0.4 / float64(d)
+		}
+	}
+	return 0
+}
+
+func abs(n int) int {
+	return max(n, -n)
+}
+
+// maxEdits is the misspellings allowed in a query term of n letters, short
+// terms must be spelled right
+func maxEdits(n int) int {
+	switch {
+	case n < 4:
+		return 0
+	case n < 8:
+		return 1
+	}
+	return 2
+}
+
+// editDistance is the Levenshtein distance between a and b, or bound+1
+// once it is known to be over bound
+func editDistance(a, b []rune, bound int) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := range a {
+		curr[0] = i + 1
+		lowest := curr[0]
+		for j := range b {
+			cost := 1
+			if a[i] == b[j] {
+				cost = 0
+			}
+			curr[j+1] = min(prev[j+1]+1, curr[j]+1, prev[j]+cost)
+			lowest = min(lowest, curr[j+1])
+		}
+		// distances never shrink from one row to the next
+		if lowest > bound {
+			return bound + 1
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(b)]
+}
+
+// nameIndex is the inverted index of ProductSlice, from the terms of the
+// product names to their ids. It isn't safe for concurrent use.
+type nameIndex struct {
+	// postings holds the ids of each term in order
+	postings map[string][]int
+	// sorted holds the terms of postings in order, to seek them
+	sorted []string
+	// terms holds the terms of each product, to drop them when it changes
+	terms map[int][]string
+}
+
+func newNameIndex() *nameIndex {
+	return &nameIndex{postings: make(map[string][]int), terms: make(map[int][]string)}
+}
+
+// put indexes name as the one of the product with the given id, in place
+// of the one it had
+func (x *nameIndex) put(id int, name string) {
+	x.remove(id)
+	terms := searchTerms(name)
+	for _, term := range terms {
+		ids, ok := x.postings[term]
+		if !ok {
+			i, _ := slices.BinarySearch(x.sorted, term)
+			x.sorted = slices.Insert(x.sorted, i, term)
+		}
+		i, _ := slices.BinarySearch(ids, id)
+		x.postings[term] = slices.Insert(ids, i, id)
+	}
+	x.terms[id] = terms
+}
+
+// remove drops the terms of the product with the given id
+func (x *nameIndex) remove(id int) {
+	for _, term := range x.terms[id] {
+		ids := x.postings[term]
+		if i, ok := slices.BinarySearch(ids, id); ok {
+			ids = slices.Delete(ids, i, i+1)
+		}
+		if len(ids) == 0 {
+			delete(x.postings, term)
+			if i, ok := slices.BinarySearch(x.sorted, term); ok {
+				x.sorted = slices.Delete(x.sorted, i, i+1)
+			}
+			continue
+		}
+		x.postings[term] = ids
+	}
+	delete(x.terms, id)
+}
+
+func (x *nameIndex) scan(from, to string, fn func(term string, ids []int)) error {
+	i, _ := slices.BinarySearch(x.sorted, from)
+	for _, term := range x.sorted[i:] {
+		if to != "" && term >= to {
+			break
+		}
+		fn(term, x.postings[term])
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package repository
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSearchTerms(t *testing.T) {
+	t.Run("should split on punctuation, fold accents and drop repeats", func(t *testing.T) {
+		// Act
+		terms := searchTerms("Crème Brûlée - CRÈME, Æbleskiver & Straße 2x")
+		// Assert
+		require.Equal(t, []string{"creme", "brulee", "aebleskiver", "strasse", "2x"}, terms)
+	})
+	t.Run("should drop accents written as combining marks", func(t *testing.T) {
+		// Act
+		terms := searchTerms("Cre\u0300me brule\u0301e")
+		// Assert
+		require.Equal(t, []string{"creme", "brulee"}, terms)
+	})
+}
+
+func TestNameIndex(t *testing.T) {
+	t.Run("should seek the terms of a range in order", func(t *testing.T) {
+		// Arrange
+		x := newNameIndex()
+		x.put(1, "Apple pie")
+		x.put(2, "Applesauce")
+		x.put(3, "Pineapple apple")
+		x.put(4, "Apricot")
+		x.remove(4)
+		// Act
+		var terms []string
+		var ids [][]int
+		require.NoError(t, x.scan("apple", prefixEnd("apple"), func(term string, found []int) {
+			terms = append(terms, term)
+			ids = append(ids, found)
+		}))
+		// Assert
+		require.Equal(t, []string{"apple", "applesauce"}, terms)
+		require.Equal(t, [][]int{{1, 3}, {2}}, ids)
+		require.Equal(t, []string{"apple", "applesauce", "pie", "pineapple"}, x.sorted)
+	})
+}
+
+func TestMatchTerm(t *testing.T) {
+	for _, c := range []struct {
+		query, term string
+		score       float64
+	}{
+		{"apple", "apple", 1},
+		{"app", "apple", 0.75},
+		{"aple", "apple", 0.5},
+		{"pineaple", "pineapple", 0.5},
+		{"pinra", "pineapple", 0.4},
+		{"ape", "apple", 0},
+		{"orange", "apple", 0},
+		{"pineapple", "pine", 0},
+	} {
+		t.Run(c.query+" "+c.term, func(t *testing.T) {
+			require.Equal(t, c.score, matchTerm(c.query, c.term))
+		})
+	}
+}
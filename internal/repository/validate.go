@@ -0,0 +1,73 @@
+package repository
+
+import (
+	"fmt"
+	"strings"
+	"web/clase1/internal"
+)
+
+// Issue is a field of a loaded product that looks wrong. Issues don't stop
+// the startup, they are reported so the catalog can be fixed.
+type Issue struct {
+	Id      int
+	Field   string
+	Problem string
+}
+
+func (i Issue) String() string {
+	return fmt.Sprintf("product %d: %s %s", i.Id, i.Field, i.Problem)
+}
+
+// Report is the result of validating the catalog found at startup
+type Report struct {
+	Products int
+	Issues   []Issue
+}
+
+func (r Report) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d products loaded, %d issues", r.Products, len(r.Issues))
+	for _, issue := range r.Issues {
+		b.WriteString("\n  ")
+		b.WriteString(issue.String())
+	}
+	return b.String()
+}
+
+// Validate checks the products a repository loaded
+func Validate(products []product.Product) Report {
+	report := Report{Products: len(products)}
+	add := func(id int, field, problem string) {
+		report.Issues = append(report.Issues, Issue{Id: id, Field: field, Problem: problem})
+	}
+
+	codes := make(map[string]int, len(products))
+	for _, p := range products {
+		if p.Id <= 0 {
+			add(p.Id, "id", "is not positive")
+		}
+		if strings.TrimSpace(p.Name) == "" {
+			add(p.Id, "name", "is empty")
+		}
+		if p.Quantity < 0 {
+			add(p.Id, "quantity", "is negative")
+		}
+		if p.Price < 0 {
+			add(p.Id, "price", "is negative")
+		}
+		if _, err := p.ExpirationDate(); err != nil {
+			add(p.Id, "expiration", fmt.Sprintf("%q is not dd/mm/yyyy", p.Expiration))
+		}
+
+		if p.CodeValue == "" {
+			add(p.Id, "code_value", "is empty")
+			continue
+		}
+		if id, ok := codes[p.CodeValue]; ok {
+			add(p.Id, "code_value", fmt.Sprintf("%q is also used by product %d", p.CodeValue, id))
+			continue
+		}
+		codes[p.CodeValue] = p.Id
+	}
+	return report
+}
@@ -0,0 +1,29 @@
+package repository
+
+import (
+	"testing"
+	"web/clase1/internal"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidate(t *testing.T) {
+	t.Run("should report every field that looks wrong", func(t *testing.T) {
+		// Arrange
+		products := []product.Product{
+			{Id: 1, Name: "Oil", Quantity: 1, CodeValue: "A1", Expiration: "15/12/2021", Price: 1},
+			{Id: 2, Name: " ", Quantity: -1, CodeValue: "A1", Expiration: "2021-12-15", Price: -1},
+		}
+		// Act
+		report := Validate(products)
+		// Assert
+		require.Equal(t, 2, report.Products)
+		require.Equal(t, []Issue{
+			{Id: 2, Field: "name", Problem: "is empty"},
+			{Id: 2, Field: "quantity", Problem: "is negative"},
+			{Id: 2, Field: "price", Problem: "is negative"},
+			{Id: 2, Field: "expiration", Problem: `"2021-12-15" is not dd/mm/yyyy`},
+			{Id: 2, Field: "code_value", Problem: `"A1" is also used by product 1`},
+		}, report.Issues)
+	})
+}
@@ -0,0 +1,74 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+	"time"
+)
+
+// Reloader is a repository that can reread its storage, see
+// ProductSlice.Reload
+type Reloader interface {
+	Reload() (bool, error)
+}
+
+// fileState is what Watch compares to notice a change of the file
+type fileState struct {
+	size    int64
+	modTime time.Time
+}
+
+func statFile(path string) (fileState, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileState{}, err
+	}
+	return fileState{size: info.Size(), modTime: info.ModTime()}, nil
+}
+
+// Watch polls path every interval until ctx is done, reloading rp when the
+// size or modification time of the file change. Files that can't be loaded
+// are logged and rejected, rp keeps serving the products it had. The file
+// is compared to its state when Watch is called, a file that doesn't exist
+// yet is loaded once it's created. Polling runs in its own goroutine.
+func Watch(ctx context.Context, rp Reloader, path string, interval time.Duration) error {
+	last, err := statFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	go poll(ctx, rp, path, interval, last)
+	return nil
+}
+
+func poll(ctx context.Context, rp Reloader, path string, interval time.Duration, last fileState) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		// a missing file is being replaced, wait for the new one
+		state, err := statFile(path)
+		if err != nil || state == last {
+			continue
+		}
+		// stat before reloading so changes made during the reload are
+		// seen by the next poll
+		last = state
+
+		changed, err := rp.Reload()
+		if err != nil {
+			log.Printf("rejected changes to %s: %v", path, err)
+			continue
+		}
+		if changed {
+			log.Printf("reloaded products from %s", path)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package repository
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"web/clase1/internal"
+	"web/clase1/internal/repository/repositorytest"
+	"web/clase1/internal/storage"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestProductSliceReload(t *testing.T) {
+	t.Run("should swap in the products changed by another tool", func(t *testing.T) {
+		// Arrange
+		st := repositorytest.NewFixture().WithN(3).Storage()
+		rp, err := NewProductRepository(st)
+		require.NoError(t, err)
+		require.NoError(t, st.Write([]byte(`[{"id":7,"name":"external"}]`)))
+		// Act
+		changed, err := rp.Reload()
+		// Assert
+		require.NoError(t, err)
+		require.True(t, changed)
+		products, err := rp.GetAllProducts()
+		require.NoError(t, err)
+		require.Equal(t, []product.Product{{Id: 7, Name: "external"}}, products)
+	})
+	t.Run("should keep serving when the new file is invalid", func(t *testing.T) {
+		// Arrange
+		st := repositorytest.NewFixture().WithN(3).Storage()
+		rp, err := NewProductRepository(st)
+		require.NoError(t, err)
+		require.NoError(t, st.Write([]byte(`[{"id":1},{"id":1}]`)))
+		// Act
+		changed, err := rp.Reload()
+		// Assert
+		require.Error(t, err)
+		require.False(t, changed)
+		products, err := rp.GetAllProducts()
+		require.NoError(t, err)
+		require.Len(t, products, 3)
+	})
+	t.Run("should not roll back to a backup when the file is corrupted", func(t *testing.T) {
+		// Arrange
+		path := seedProductsFile(t, repositorytest.Products(3))
+		st := storage.NewStorageJSON(path)
+		st.Backups = 2
+		rp, err := NewProductRepository(st)
+		require.NoError(t, err)
+		require.NoError(t, rp.CreateProduct(&product.Product{Name: "new", CodeValue: "N"}))
+		require.NoError(t, os.WriteFile(path, []byte(`[{"id":1},`), 0644))
+		// Act
+		changed, err := rp.Reload()
+		// Assert
+		require.ErrorIs(t, err, storage.ErrInvalidJSON)
+		require.False(t, changed)
+		products, err := rp.GetAllProducts()
+		require.NoError(t, err)
+		require.Len(t, products, 4)
+	})
+	t.Run("should take the sequence of a file whose products didn't change", func(t *testing.T) {
+		// Arrange
+		st := repositorytest.NewFixture().WithN(3).Storage()
+		rp, err := NewProductRepository(st)
+		require.NoError(t, err)
+		other, err := NewProductRepository(st)
+		require.NoError(t, err)
+		p := product.Product{Name: "other", CodeValue: "O"}
+		require.NoError(t, other.CreateProduct(&p))
+		require.NoError(t, other.DeleteProduct(p.Id))
+		changed, err := rp.Reload()
+		require.NoError(t, err)
+		require.False(t, changed)
+		// Act
+		p = product.Product{Name: "new", CodeValue: "N"}
+		require.NoError(t, rp.CreateProduct(&p))
+		// Assert
+		require.Equal(t, 5, p.Id)
+	})
+	t.Run("should not reuse ids handed out before the reload", func(t *testing.T) {
+		// Arrange
+		st := repositorytest.NewFixture().WithN(3).Storage()
+		rp, err := NewProductRepository(st)
+		require.NoError(t, err)
+		require.NoError(t, st.Write([]byte(`[{"id":1}]`)))
+		_, err = rp.Reload()
+		require.NoError(t, err)
+		// Act
+		p := product.Product{Name: "new", CodeValue: "N"}
+		require.NoError(t, rp.CreateProduct(&p))
+		// Assert
+		require.Equal(t, 4, p.Id)
+	})
+}
+
+func TestWatch(t *testing.T) {
+	t.Run("should reload the file when it changes on disk", func(t *testing.T) {
+		// Arrange
+		path := seedProductsFile(t, repositorytest.Products(3))
+		rp, err := NewProductRepository(storage.NewStorageJSON(path))
+		require.NoError(t, err)
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		require.NoError(t, Watch(ctx, rp, path, 10*time.Millisecond))
+		// Act
+		require.NoError(t, os.WriteFile(path, []byte(`[]`), 0644))
+		// Assert
+		require.Eventually(t, func() bool {
+			_, err := rp.GetAllProducts()
+			return err != nil
+		}, time.Second, 10*time.Millisecond)
+	})
+	t.Run("should wait for a missing file the repository was created without", func(t *testing.T) {
+		// Arrange
+		path := filepath.Join(t.TempDir(), "products.json")
+		rp, err := New(Config{Backend: BackendJSON, Path: path, CreateMissing: true})
+		require.NoError(t, err)
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		// Act
+		require.NoError(t, Watch(ctx, rp.(Reloader), path, 10*time.Millisecond))
+		require.NoError(t, os.WriteFile(path, []byte(`[{"id":9,"name":"created"}]`), 0644))
+		// Assert
+		require.Eventually(t, func() bool {
+			p, err := rp.GetProductById(9)
+			return err == nil && p.Name == "created"
+		}, time.Second, 10*time.Millisecond)
+	})
+	t.Run("should reject an invalid file and pick up the next valid one", func(t *testing.T) {
+		// Arrange
+		path := seedProductsFile(t, repositorytest.Products(3))
+		rp, err := NewProductRepository(storage.NewStorageJSON(path))
+		require.NoError(t, err)
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		require.NoError(t, Watch(ctx, rp, path, 10*time.Millisecond))
+		// Act
+		require.NoError(t, os.WriteFile(path, []byte(`[{"id":1},`), 0644))
+		time.Sleep(50 * time.Millisecond)
+		products, err := rp.GetAllProducts()
+		require.NoError(t, err)
+		require.Len(t, products, 3)
+		require.NoError(t, os.WriteFile(path, []byte(`[{"id":9,"name":"fixed"}]`), 0644))
+		// Assert
+		require.Eventually(t, func() bool {
+			p, err := rp.GetProductById(9)
+			return err == nil && p.Name == "fixed"
+		}, time.Second, 10*time.Millisecond)
+	})
+	t.Run("should reject a corrupted file instead of loading a backup", func(t *testing.T) {
+		// Arrange
+		path := seedProductsFile(t, repositorytest.Products(3))
+		st := storage.NewStorageJSON(path)
+		st.Backups = 2
+		rp, err := NewProductRepository(st)
+		require.NoError(t, err)
+		require.NoError(t, rp.CreateProduct(&product.Product{Name: "new", CodeValue: "N"}))
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		require.NoError(t, Watch(ctx, rp, path, 10*time.Millisecond))
+		// Act
+		require.NoError(t, os.WriteFile(path, []byte(`[{"id":1},`), 0644))
+		time.Sleep(50 * time.Millisecond)
+		// Assert
+		products, err := rp.GetAllProducts()
+		require.NoError(t, err)
+		require.Len(t, products, 4)
+		require.NoError(t, rp.CreateProduct(&product.Product{Name: "newer", CodeValue: "NN"}))
+		stored, err := NewProductRepository(storage.NewStorageJSON(path))
+		require.NoError(t, err)
+		products, err = stored.GetAllProducts()
+		require.NoError(t, err)
+		require.Len(t, products, 5)
+	})
+}
@@ -1,7 +1,10 @@
 package service
 
 import (
+	"context"
+	"log"
 	"web/clase1/internal"
+	"web/clase1/internal/auth"
 )
 
 type Service struct {
@@ -26,22 +29,59 @@ func (s *Service) GetProductById(id int) (*product.Product, error) {
 	return p, nil
 }
 
+func (s *Service) GetProductByCode(code string) (*product.Product, error) {
+	return s.repository.GetProductByCode(code)
+}
+
 func (s *Service) FindProductsByPriceGt(price float64) []product.Product {
 	return s.repository.FindProductsByPriceGt(price)
 }
 
-func (s *Service) CreateProduct(product *product.Product) (err error) {
-	return s.repository.CreateProduct(product)
+func (s *Service) FindProducts(filter product.Filter, page product.Page) (product.ProductPage, error) {
+	return s.repository.FindProducts(filter, page)
 }
 
-func (s *Service) UpdateOrCreateProduct(product *product.RequestBodyProduct, id int) error {
-	return s.repository.UpdateOrCreateProduct(product, id)
+func (s *Service) SearchProducts(query string, page product.Page) (product.ProductPage, error) {
+	return s.repository.SearchProducts(query, page)
 }
 
-func (s *Service) UpdatePartial(product map[string]any, id int) error {
-	return s.repository.UpdatePartial(product, id)
+func (s *Service) CreateProduct(ctx context.Context, product *product.Product) (err error) {
+	if err = s.repository.CreateProduct(product); err != nil {
+		return err
+	}
+	audit(ctx, "created", product.Id)
+	return nil
 }
 
-func (s *Service) DeleteProduct(id int) error {
-	return s.repository.DeleteProduct(id)
+func (s *Service) UpdateOrCreateProduct(ctx context.Context, product *product.RequestBodyProduct, id int) error {
+	if err := s.repository.UpdateOrCreateProduct(product, id); err != nil {
+		return err
+	}
+	audit(ctx, "replaced", id)
+	return nil
+}
+
+func (s *Service) UpdatePartial(ctx context.Context, product map[string]any, id int) error {
+	if err := s.repository.UpdatePartial(product, id); err != nil {
+		return err
+	}
+	audit(ctx, "updated", id)
+	return nil
+}
+
+func (s *Service) DeleteProduct(ctx context.Context, id int) error {
+	if err := s.repository.DeleteProduct(id); err != nil {
+		return err
+	}
+	audit(ctx, "deleted", id)
+	return nil
+}
+
+// audit logs who changed a product, as set in ctx by the auth middleware
+func audit(ctx context.Context, action string, id int) {
+	caller := "anonymous"
+	if identity, ok := auth.IdentityFromContext(ctx); ok {
+		caller = identity.Name
+	}
+	log.Printf("product %d %s by %s", id, action, caller)
 }
@@ -0,0 +1,69 @@
+package product
+
+import (
+	"cmp"
+	"strings"
+	"time"
+)
+
+// SortFields are the JSON names of the Product fields listings can be
+// sorted by
+var SortFields = []string{"id", "name", "quantity", "code_value", "is_published", "expiration", "price"}
+
+// SortField orders a listing by the Product field whose JSON name is Field
+type SortField struct {
+	Field string
+	Desc  bool
+}
+
+// Compare orders a and b by sort, then by id so the order is total.
+// Expirations compare as dates, the ones that don't parse first.
+func Compare(a, b Product, sort []SortField) int {
+	for _, f := range sort {
+		c := compareField(a, b, f.Field)
+		if f.Desc {
+			c = -c
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return cmp.Compare(a.Id, b.Id)
+}
+
+func compareField(a, b Product, field string) int {
+	switch field {
+	case "id":
+		return cmp.Compare(a.Id, b.Id)
+	case "name":
+		return strings.Compare(a.Name, b.Name)
+	case "quantity":
+		return cmp.Compare(a.Quantity, b.Quantity)
+	case "code_value":
+		return strings.Compare(a.CodeValue, b.CodeValue)
+	case "is_published":
+		return cmp.Compare(boolRank(a.Is_Published), boolRank(b.Is_Published))
+	case "expiration":
+		return strings.Compare(a.ExpirationISO(), b.ExpirationISO())
+	case "price":
+		return cmp.Compare(a.Price, b.Price)
+	}
+	return 0
+}
+
+func boolRank(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// ExpirationISO is Expiration as an ISO date, which orders like the date.
+// It is empty when Expiration doesn't parse.
+func (p Product) ExpirationISO() string {
+	date, err := p.ExpirationDate()
+	if err != nil {
+		return ""
+	}
+	return date.Format(time.DateOnly)
+}
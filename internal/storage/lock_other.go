@@ -0,0 +1,15 @@
+//go:build !unix
+
+package storage
+
+import "os"
+
+// lockFile is a no-op where flock isn't available, stale writes are still
+// detected by the content hash
+func lockFile(f *os.File, exclusive bool) error {
+	return nil
+}
+
+func unlockFile(f *os.File) error {
+	return nil
+}
@@ -0,0 +1,35 @@
+//go:build unix
+
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLockFile(t *testing.T) {
+	t.Run("should wait for the lock held by another process", func(t *testing.T) {
+		// Arrange
+		path := filepath.Join(t.TempDir(), "products.json")
+		other, err := os.OpenFile(path+".lock", os.O_RDWR|os.O_CREATE, 0644)
+		require.NoError(t, err)
+		defer other.Close()
+		require.NoError(t, lockFile(other, true))
+		st := NewStorageFile(path)
+		// Act
+		done := make(chan error)
+		go func() { done <- st.Write([]byte(`[1]`)) }()
+		// Assert
+		select {
+		case <-done:
+			t.Fatal("write did not wait for the lock")
+		case <-time.After(50 * time.Millisecond):
+		}
+		require.NoError(t, unlockFile(other))
+		require.NoError(t, <-done)
+	})
+}
@@ -1,6 +1,41 @@
 package storage
 
+import "io"
+
 type Storage interface {
 	Read() ([]byte, error)
 	Write([]byte) error
 }
+
+// Journal is a Storage that can also record single changes. Read returns
+// the last snapshot, Replay the changes appended after it, and Write
+// stores a new snapshot, discarding the changes it includes.
+type Journal interface {
+	Storage
+	// Append records a change. It reports whether enough changes piled up
+	// that the caller should Write a new snapshot.
+	Append(entry []byte) (compact bool, err error)
+	Replay(apply func(entry []byte) error) error
+}
+
+// CurrentReader is implemented by storages that fall back to backups when
+// their file is broken. ReadCurrent reads the file itself and fails rather
+// than return a backup, for readers that must not roll changes back.
+type CurrentReader interface {
+	ReadCurrent() ([]byte, error)
+}
+
+// ReadCurrent reads st without falling back to backups, storages keeping
+// none are simply read
+func ReadCurrent(st Storage) ([]byte, error) {
+	if r, ok := st.(CurrentReader); ok {
+		return r.ReadCurrent()
+	}
+	return st.Read()
+}
+
+// Streamer is implemented by storages that can hand out their content as a
+// stream, so readers can decode it without holding it all in memory
+type Streamer interface {
+	ReadStream() (io.ReadCloser, error)
+}
@@ -0,0 +1,184 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"path/filepath"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	CompressionNone = "none"
+	CompressionGzip = "gzip"
+	CompressionZstd = "zstd"
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// CompressionForPath picks the compression matching the file extension
+func CompressionForPath(path string) string {
+	switch filepath.Ext(path) {
+	case ".gz":
+		return CompressionGzip
+	case ".zst":
+		return CompressionZstd
+	}
+	return CompressionNone
+}
+
+// StorageCompressed compresses what it writes to the wrapped Storage with
+// Compression. Reads detect the format from the magic bytes, so files
+// written with another compression, or none, are still read.
+//
+// A wrapped StorageJSON checks the decompressed data, as its Valid is
+// wrapped to decompress first. The WAL journal is appended record by
+// record and isn't compressed.
+type StorageCompressed struct {
+	Storage     Storage
+	Compression string
+}
+
+func NewStorageCompressed(st Storage, compression string) *StorageCompressed {
+	if js, ok := st.(*StorageJSON); ok {
+		valid := js.Valid
+		js.Valid = func(data []byte) bool {
+			plain, err := decompress(data)
+			return err == nil && (valid == nil || valid(plain))
+		}
+	}
+	return &StorageCompressed{
+		Storage:     st,
+		Compression: compression,
+	}
+}
+
+// NewStorageCompressedFile compresses fileName as its extension says, and
+// keeps the given number of backups. Read falls back to a backup when the
+// file doesn't decompress to JSON.
+func NewStorageCompressedFile(fileName string, backups int) *StorageCompressed {
+	file := NewStorageJSON(fileName)
+	file.Backups = backups
+
+	return NewStorageCompressed(file, CompressionForPath(fileName))
+}
+
+func (s *StorageCompressed) Read() ([]byte, error) {
+	data, err := s.Storage.Read()
+	if err != nil {
+		return nil, err
+	}
+	return decompress(data)
+}
+
+func (s *StorageCompressed) ReadCurrent() ([]byte, error) {
+	data, err := ReadCurrent(s.Storage)
+	if err != nil {
+		return nil, err
+	}
+	return decompress(data)
+}
+
+// ReadStream decompresses the stream of the wrapped storage as it's read,
+// it fails if the wrapped storage can't stream
+func (s *StorageCompressed) ReadStream() (io.ReadCloser, error) {
+	streamer, ok := s.Storage.(Streamer)
+	if !ok {
+		return nil, fmt.Errorf("%T can't stream", s.Storage)
+	}
+	stream, err := streamer.ReadStream()
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := newDecompressor(bufio.NewReader(stream))
+	if err != nil {
+		stream.Close()
+		return nil, err
+	}
+	return readCloser{Reader: r, close: func() error {
+		if c, ok := r.(io.Closer); ok {
+			c.Close()
+		}
+		return stream.Close()
+	}}, nil
+}
+
+func (s *StorageCompressed) Write(data []byte) error {
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch s.Compression {
+	case CompressionGzip:
+		w = gzip.NewWriter(&buf)
+	case CompressionZstd:
+		var err error
+		if w, err = zstd.NewWriter(&buf); err != nil {
+			return err
+		}
+	case CompressionNone, "":
+		return s.Storage.Write(data)
+	default:
+		return fmt.Errorf("unknown compression %q", s.Compression)
+	}
+
+	if _, err := w.Write(data); err != nil {
+		w.Close()
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return s.Storage.Write(buf.Bytes())
+}
+
+func decompress(data []byte) ([]byte, error) {
+	r, err := newDecompressor(bufio.NewReader(bytes.NewReader(data)))
+	if err != nil {
+		return nil, err
+	}
+	if c, ok := r.(io.Closer); ok {
+		defer c.Close()
+	}
+	return io.ReadAll(r)
+}
+
+// newDecompressor detects the compression of r from its magic bytes
+func newDecompressor(r *bufio.Reader) (io.Reader, error) {
+	magic, _ := r.Peek(len(zstdMagic))
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		return gzip.NewReader(r)
+	case bytes.HasPrefix(magic, zstdMagic):
+		d, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return zstdReader{d}, nil
+	}
+	return r, nil
+}
+
+// zstdReader adapts the Close of a zstd decoder, which returns nothing
+type zstdReader struct {
+	*zstd.Decoder
+}
+
+func (r zstdReader) Close() error {
+	r.Decoder.Close()
+	return nil
+}
+
+type readCloser struct {
+	io.Reader
+	close func() error
+}
+
+func (r readCloser) Close() error {
+	return r.close()
+}
@@ -0,0 +1,121 @@
+package storage
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStorageCompressed(t *testing.T) {
+	data := []byte(`[{"id":1,"name":"Oil - Margarine"},{"id":2,"name":"Oil - Margarine"}]`)
+
+	for _, compression := range []string{CompressionGzip, CompressionZstd} {
+		t.Run(compression+" should round trip and stream", func(t *testing.T) {
+			// Arrange
+			path := filepath.Join(t.TempDir(), "products.json")
+			st := NewStorageCompressed(NewStorageFile(path), compression)
+			// Act
+			require.NoError(t, st.Write(data))
+			// Assert
+			raw, err := os.ReadFile(path)
+			require.NoError(t, err)
+			require.NotEqual(t, data, raw)
+
+			read, err := st.Read()
+			require.NoError(t, err)
+			require.Equal(t, data, read)
+
+			stream, err := st.ReadStream()
+			require.NoError(t, err)
+			streamed, err := io.ReadAll(stream)
+			require.NoError(t, err)
+			require.NoError(t, stream.Close())
+			require.Equal(t, data, streamed)
+		})
+	}
+	t.Run("should check the decompressed data of a wrapped StorageJSON", func(t *testing.T) {
+		// Arrange
+		path := filepath.Join(t.TempDir(), "products.json.gz")
+		st := NewStorageCompressed(NewStorageJSON(path), CompressionGzip)
+		// Act
+		require.NoError(t, st.Write(data))
+		err := st.Write([]byte(`[{"id":1},`))
+		// Assert
+		require.ErrorIs(t, err, ErrInvalidJSON)
+		raw, err := os.ReadFile(path)
+		require.NoError(t, err)
+		require.NotEqual(t, data, raw)
+		read, err := st.Read()
+		require.NoError(t, err)
+		require.Equal(t, data, read)
+	})
+	t.Run("should pick the compression from the extension", func(t *testing.T) {
+		require.Equal(t, CompressionGzip, CompressionForPath("products.json.gz"))
+		require.Equal(t, CompressionZstd, CompressionForPath("products.json.zst"))
+		require.Equal(t, CompressionNone, CompressionForPath("products.json"))
+	})
+	t.Run("should read a file written with another compression or none", func(t *testing.T) {
+		// Arrange
+		path := filepath.Join(t.TempDir(), "products.json.zst")
+		require.NoError(t, NewStorageCompressed(NewStorageFile(path), CompressionGzip).Write(data))
+		st := NewStorageCompressedFile(path, 0)
+		// Act
+		read, err := st.Read()
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(path, data, 0644))
+		plain, err := st.Read()
+		require.NoError(t, err)
+		// Assert
+		require.Equal(t, data, read)
+		require.Equal(t, data, plain)
+	})
+	t.Run("should fall back to a backup that decompresses", func(t *testing.T) {
+		// Arrange
+		path := filepath.Join(t.TempDir(), "products.json.gz")
+		st := NewStorageCompressedFile(path, 1)
+		require.NoError(t, st.Write(data))
+		require.NoError(t, st.Write([]byte(`[]`)))
+		raw, err := os.ReadFile(path)
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(path, raw[:len(raw)/2], 0644))
+		// Act
+		read, err := st.Read()
+		// Assert
+		require.NoError(t, err)
+		require.Equal(t, data, read)
+	})
+}
+
+// BenchmarkStorageCompressed writes and reads the sample catalog with each
+// compression, reporting the size on disk
+//
+//	go test ./internal/storage -run '^$' -bench StorageCompressed
+func BenchmarkStorageCompressed(b *testing.B) {
+	data, err := os.ReadFile("../../docs/db/products.json")
+	require.NoError(b, err)
+
+	for _, compression := range []string{CompressionNone, CompressionGzip, CompressionZstd} {
+		path := filepath.Join(b.TempDir(), "products")
+		st := NewStorageCompressed(NewStorageFile(path), compression)
+
+		b.Run(compression+"/write", func(b *testing.B) {
+			b.SetBytes(int64(len(data)))
+			for i := 0; i < b.N; i++ {
+				require.NoError(b, st.Write(data))
+			}
+			info, err := os.Stat(path)
+			require.NoError(b, err)
+			b.ReportMetric(float64(info.Size()), "bytes_on_disk")
+		})
+		b.Run(compression+"/read", func(b *testing.B) {
+			b.SetBytes(int64(len(data)))
+			for i := 0; i < b.N; i++ {
+				_, err := st.Read()
+				require.NoError(b, err)
+			}
+		})
+	}
+}
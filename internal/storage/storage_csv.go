@@ -0,0 +1,277 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"strconv"
+	"strings"
+	"web/clase1/internal"
+)
+
+var ErrCSVHeader = errors.New("invalid csv header")
+
+// CSVColumns are the columns of a products CSV file, in the order written.
+// Files may list them in any order.
+var CSVColumns = []string{"id", "name", "quantity", "code_value", "is_published", "expiration", "price"}
+
+// CSVError reports a value of a CSV file that doesn't parse. Row counts
+// the header as row 1, as spreadsheets do.
+type CSVError struct {
+	Row    int
+	Column string
+	Err    error
+}
+
+func (e *CSVError) Error() string {
+	if e.Column == "" {
+		return fmt.Sprintf("row %d: %v", e.Row, e.Err)
+	}
+	return fmt.Sprintf("row %d, column %s: %v", e.Row, e.Column, e.Err)
+}
+
+func (e *CSVError) Unwrap() error {
+	return e.Err
+}
+
+// StorageCSV keeps products as CSV in the wrapped Storage, so they can be
+// edited in a spreadsheet. It converts to and from the JSON the
+// repositories use. The id sequence of the document is kept in Sequence,
+// without it the sequence is recomputed from the highest id on read.
+type StorageCSV struct {
+	Storage  Storage
+	Sequence Storage
+}
+
+// csvDocument is the JSON document read from a CSV file with a sequence
+type csvDocument struct {
+	LastId   int               `json:"last_id"`
+	Products []product.Product `json:"products"`
+}
+
+func NewStorageCSV(st Storage) *StorageCSV {
+	return &StorageCSV{
+		Storage: st,
+	}
+}
+
+// NewStorageCSVFile keeps the products as CSV in fileName with the given
+// number of backups, and the id sequence in fileName.seq
+func NewStorageCSVFile(fileName string, backups int) *StorageCSV {
+	file := NewStorageFile(fileName)
+	file.Backups = backups
+	st := NewStorageCSV(file)
+	st.Sequence = NewStorageFile(fileName + ".seq")
+	return st
+}
+
+// Read returns the products of the CSV file as a JSON array, or as a
+// document with a "last_id" when a sequence was kept
+func (s *StorageCSV) Read() ([]byte, error) {
+	data, err := s.Storage.Read()
+	if err != nil {
+		return nil, err
+	}
+	return s.toJSON(data)
+}
+
+func (s *StorageCSV) ReadCurrent() ([]byte, error) {
+	data, err := ReadCurrent(s.Storage)
+	if err != nil {
+		return nil, err
+	}
+	return s.toJSON(data)
+}
+
+// toJSON converts the products of a CSV file to JSON, see Read
+func (s *StorageCSV) toJSON(data []byte) ([]byte, error) {
+	products, err := DecodeCSV(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	lastId, err := s.readSequence()
+	if err != nil {
+		return nil, err
+	}
+	if lastId == 0 {
+		return json.Marshal(products)
+	}
+	return json.Marshal(csvDocument{LastId: lastId, Products: products})
+}
+
+// readSequence returns the id sequence kept in Sequence, 0 when there is
+// none
+func (s *StorageCSV) readSequence() (int, error) {
+	if s.Sequence == nil {
+		return 0, nil
+	}
+	data, err := s.Sequence.Read()
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	lastId, err := strconv.Atoi(string(bytes.TrimSpace(data)))
+	if err != nil {
+		return 0, fmt.Errorf("id sequence: %w", err)
+	}
+	return lastId, nil
+}
+
+// Write stores the products of a JSON document, either a bare array or an
+// object with a "products" array. The "last_id" of the object is written
+// to Sequence first, so a failed write never lowers it.
+func (s *StorageCSV) Write(data []byte) error {
+	var doc csvDocument
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		if err := json.Unmarshal(data, &doc); err != nil {
+			return err
+		}
+	} else if err := json.Unmarshal(data, &doc.Products); err != nil {
+		return err
+	}
+	products := doc.Products
+
+	if s.Sequence != nil && doc.LastId > 0 {
+		if err := s.Sequence.Write([]byte(strconv.Itoa(doc.LastId) + "\n")); err != nil {
+			return err
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := EncodeCSV(&buf, products); err != nil {
+		return err
+	}
+	return s.Storage.Write(buf.Bytes())
+}
+
+// DecodeCSV parses products from CSV, checking the header holds exactly
+// CSVColumns
+func DecodeCSV(r io.Reader) ([]product.Product, error) {
+	// every row must have as many fields as the header
+	reader := csv.NewReader(r)
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, fmt.Errorf("%w: missing header", ErrCSVHeader)
+	}
+	if err != nil {
+		return nil, csvReadError(err, 1)
+	}
+
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		name = strings.TrimSpace(strings.TrimPrefix(name, "\ufeff"))
+		if !isCSVColumn(name) {
+			return nil, fmt.Errorf("%w: unknown column %q", ErrCSVHeader, name)
+		}
+		if _, ok := columns[name]; ok {
+			return nil, fmt.Errorf("%w: duplicated column %q", ErrCSVHeader, name)
+		}
+		columns[name] = i
+	}
+	for _, column := range CSVColumns {
+		if _, ok := columns[column]; !ok {
+			return nil, fmt.Errorf("%w: missing column %q", ErrCSVHeader, column)
+		}
+	}
+
+	products := []product.Product{}
+	for row := 2; ; row++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return products, nil
+		}
+		if err != nil {
+			return nil, csvReadError(err, row)
+		}
+
+		p, err := parseCSVRecord(record, columns, row)
+		if err != nil {
+			return nil, err
+		}
+		products = append(products, p)
+	}
+}
+
+// EncodeCSV writes products as CSV with a CSVColumns header. Prices are
+// written with the fewest digits that read back as the same float.
+func EncodeCSV(w io.Writer, products []product.Product) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(CSVColumns); err != nil {
+		return err
+	}
+	for _, p := range products {
+		err := writer.Write([]string{
+			strconv.Itoa(p.Id),
+			p.Name,
+			strconv.Itoa(p.Quantity),
+			p.CodeValue,
+			strconv.FormatBool(p.Is_Published),
+			p.Expiration,
+			strconv.FormatFloat(p.Price, 'f', -1, 64),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+func parseCSVRecord(record []string, columns map[string]int, row int) (product.Product, error) {
+	var p product.Product
+	var err error
+	for _, column := range CSVColumns {
+		value := record[columns[column]]
+		switch column {
+		case "id":
+			p.Id, err = strconv.Atoi(strings.TrimSpace(value))
+		case "name":
+			p.Name = value
+		case "quantity":
+			p.Quantity, err = strconv.Atoi(strings.TrimSpace(value))
+		case "code_value":
+			p.CodeValue = value
+		case "is_published":
+			p.Is_Published, err = strconv.ParseBool(strings.TrimSpace(value))
+		case "expiration":
+			p.Expiration = value
+		case "price":
+			p.Price, err = strconv.ParseFloat(strings.TrimSpace(value), 64)
+		}
+		if err != nil {
+			// strconv errors repeat the value, keep only the reason
+			var numErr *strconv.NumError
+			if errors.As(err, &numErr) {
+				err = fmt.Errorf("%q: %w", value, numErr.Err)
+			}
+			return p, &CSVError{Row: row, Column: column, Err: err}
+		}
+	}
+	return p, nil
+}
+
+func isCSVColumn(name string) bool {
+	for _, column := range CSVColumns {
+		if column == name {
+			return true
+		}
+	}
+	return false
+}
+
+// csvReadError reports a malformed row, or one with the wrong number of
+// fields, as a CSVError
+func csvReadError(err error, row int) error {
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) {
+		return &CSVError{Row: row, Err: parseErr.Err}
+	}
+	return err
+}
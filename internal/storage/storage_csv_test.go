@@ -0,0 +1,84 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"web/clase1/internal"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStorageCSV(t *testing.T) {
+	t.Run("should round trip the catalog without losing fields", func(t *testing.T) {
+		// Arrange
+		data, err := os.ReadFile("../../docs/db/products.json")
+		require.NoError(t, err)
+		var expected []product.Product
+		require.NoError(t, json.Unmarshal(data, &expected))
+		st := NewStorageCSVFile(filepath.Join(t.TempDir(), "products.csv"), 0)
+		// Act
+		require.NoError(t, st.Write(data))
+		read, err := st.Read()
+		// Assert
+		require.NoError(t, err)
+		var products []product.Product
+		require.NoError(t, json.Unmarshal(read, &products))
+		require.Equal(t, expected, products)
+	})
+	t.Run("should keep the id sequence of a document", func(t *testing.T) {
+		// Arrange
+		path := filepath.Join(t.TempDir(), "products.csv")
+		data := `{"last_id":7,"products":[{"id":2,"name":"Oil"}]}`
+		require.NoError(t, NewStorageCSVFile(path, 0).Write([]byte(data)))
+		// Act
+		read, err := NewStorageCSVFile(path, 0).Read()
+		// Assert
+		require.NoError(t, err)
+		var doc struct {
+			LastId   int               `json:"last_id"`
+			Products []product.Product `json:"products"`
+		}
+		require.NoError(t, json.Unmarshal(read, &doc))
+		require.Equal(t, 7, doc.LastId)
+		require.Equal(t, []product.Product{{Id: 2, Name: "Oil"}}, doc.Products)
+		require.FileExists(t, path+".seq")
+	})
+	t.Run("should accept the columns in any order", func(t *testing.T) {
+		// Arrange
+		csv := "price,id,name,quantity,code_value,is_published,expiration\n" +
+			"10.5,7,\"Wine, Red\",3,W1,true,01/02/2024\n"
+		// Act
+		products, err := DecodeCSV(strings.NewReader(csv))
+		// Assert
+		require.NoError(t, err)
+		require.Equal(t, []product.Product{{
+			Id: 7, Name: "Wine, Red", Quantity: 3, CodeValue: "W1",
+			Is_Published: true, Expiration: "01/02/2024", Price: 10.5,
+		}}, products)
+	})
+	t.Run("should reject a header missing a column", func(t *testing.T) {
+		// Arrange
+		csv := "id,name,quantity,code_value,is_published,expiration\n"
+		// Act
+		_, err := DecodeCSV(strings.NewReader(csv))
+		// Assert
+		require.ErrorIs(t, err, ErrCSVHeader)
+	})
+	t.Run("should report the row and column of an invalid value", func(t *testing.T) {
+		// Arrange
+		csv := strings.Join(CSVColumns, ",") + "\n" +
+			"1,Oil,439,S82254D,true,15/12/2021,71.42\n" +
+			"2,Pineapple,345,M4637,true,09/08/2021,cheap\n"
+		// Act
+		_, err := DecodeCSV(strings.NewReader(csv))
+		// Assert
+		var csvErr *CSVError
+		require.True(t, errors.As(err, &csvErr))
+		require.Equal(t, 3, csvErr.Row)
+		require.Equal(t, "price", csvErr.Column)
+	})
+}
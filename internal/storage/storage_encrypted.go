@@ -0,0 +1,188 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"web/clase1/platform/tools"
+)
+
+var (
+	ErrDecrypt   = errors.New("data failed authentication, wrong key or tampered file")
+	ErrPlaintext = errors.New("data is not encrypted")
+)
+
+// encryptedMagic starts every encrypted file, followed by the format
+// version, the id of the key and the nonce
+var encryptedMagic = []byte("PENC")
+
+const (
+	encryptedVersion = 1
+	keyIdSize        = 8
+)
+
+// StorageEncrypted seals what it writes to the wrapped Storage with
+// AES-256-GCM. It encrypts with the first key and decrypts with whichever
+// key the data was sealed with, so rotating means putting the new key first:
+// the next write re-encrypts everything with it.
+type StorageEncrypted struct {
+	Storage Storage
+	// AllowPlaintext lets Read return unencrypted data, to encrypt an
+	// existing file on its next write
+	AllowPlaintext bool
+
+	keys []encryptionKey
+}
+
+type encryptionKey struct {
+	id   []byte
+	aead cipher.AEAD
+}
+
+func NewStorageEncrypted(st Storage, keys [][]byte) (*StorageEncrypted, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("no encryption keys")
+	}
+
+	s := &StorageEncrypted{
+		Storage: st,
+	}
+	for i, key := range keys {
+		if len(key) != 32 {
+			return nil, fmt.Errorf("encryption key %d: must be 32 bytes, got %d", i+1, len(key))
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(key)
+		s.keys = append(s.keys, encryptionKey{id: sum[:keyIdSize], aead: aead})
+	}
+	return s, nil
+}
+
+func (s *StorageEncrypted) Read() ([]byte, error) {
+	data, err := s.Storage.Read()
+	if err != nil {
+		return nil, err
+	}
+	return s.open(data)
+}
+
+func (s *StorageEncrypted) ReadCurrent() ([]byte, error) {
+	data, err := ReadCurrent(s.Storage)
+	if err != nil {
+		return nil, err
+	}
+	return s.open(data)
+}
+
+// open decrypts data with the key it was sealed with
+func (s *StorageEncrypted) open(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, encryptedMagic) {
+		if s.AllowPlaintext {
+			return data, nil
+		}
+		return nil, ErrPlaintext
+	}
+
+	headerSize := len(encryptedMagic) + 1 + keyIdSize
+	if len(data) < headerSize || data[len(encryptedMagic)] != encryptedVersion {
+		return nil, ErrDecrypt
+	}
+	header := data[:headerSize]
+	id := header[len(encryptedMagic)+1:]
+
+	for _, key := range s.keys {
+		if !bytes.Equal(key.id, id) {
+			continue
+		}
+		nonceSize := key.aead.NonceSize()
+		if len(data) < headerSize+nonceSize {
+			return nil, ErrDecrypt
+		}
+		nonce := data[headerSize : headerSize+nonceSize]
+		plain, err := key.aead.Open(nil, nonce, data[headerSize+nonceSize:], header)
+		if err != nil {
+			return nil, ErrDecrypt
+		}
+		return plain, nil
+	}
+	return nil, fmt.Errorf("%w: no configured key matches", ErrDecrypt)
+}
+
+func (s *StorageEncrypted) Write(data []byte) error {
+	key := s.keys[0]
+
+	var header []byte
+	header = append(header, encryptedMagic...)
+	header = append(header, encryptedVersion)
+	header = append(header, key.id...)
+
+	nonce := make([]byte, key.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+
+	// the header is authenticated too, dst must not overlap it
+	sealed := make([]byte, 0, len(header)+len(nonce)+len(data)+key.aead.Overhead())
+	sealed = append(sealed, header...)
+	sealed = append(sealed, nonce...)
+	sealed = key.aead.Seal(sealed, nonce, data, header)
+	return s.Storage.Write(sealed)
+}
+
+// ParseEncryptionKeys decodes base64 keys separated by spaces, commas or
+// newlines. The first one is used to encrypt.
+func ParseEncryptionKeys(text string) ([][]byte, error) {
+	fields := strings.FieldsFunc(text, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\n' || r == '\r' || r == '\t'
+	})
+
+	var keys [][]byte
+	for i, field := range fields {
+		key, err := base64.StdEncoding.DecodeString(field)
+		if err != nil {
+			return nil, fmt.Errorf("encryption key %d: %w", i+1, err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// LoadEncryptionKeys reads the keys from a key file, one per line
+func LoadEncryptionKeys(path string) ([][]byte, error) {
+	data, err := tools.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := ParseEncryptionKeys(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return keys, nil
+}
+
+// EncryptionKeysFromEnv reads the keys from an environment variable
+func EncryptionKeysFromEnv(name string) ([][]byte, error) {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return nil, fmt.Errorf("environment variable %s is not set", name)
+	}
+	keys, err := ParseEncryptionKeys(value)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return keys, nil
+}
@@ -0,0 +1,110 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newTestKey(t *testing.T) []byte {
+	t.Helper()
+
+	key := make([]byte, 32)
+	_, err := rand.Read(key)
+	require.NoError(t, err)
+	return key
+}
+
+// newTestFile returns a raw file storage, StorageMemory only takes JSON
+func newTestFile(t *testing.T) *StorageFile {
+	t.Helper()
+
+	return NewStorageFile(filepath.Join(t.TempDir(), "products.json.enc"))
+}
+
+func TestStorageEncrypted(t *testing.T) {
+	data := []byte(`[{"id":1,"price":71.42}]`)
+
+	t.Run("should round trip without writing plaintext", func(t *testing.T) {
+		// Arrange
+		file := newTestFile(t)
+		st, err := NewStorageEncrypted(file, [][]byte{newTestKey(t)})
+		require.NoError(t, err)
+		// Act
+		require.NoError(t, st.Write(data))
+		// Assert
+		sealed, err := file.Read()
+		require.NoError(t, err)
+		require.False(t, bytes.Contains(sealed, []byte("71.42")))
+		read, err := st.Read()
+		require.NoError(t, err)
+		require.Equal(t, data, read)
+	})
+	t.Run("should re-encrypt with the new key after a rotation", func(t *testing.T) {
+		// Arrange
+		oldKey, newKey := newTestKey(t), newTestKey(t)
+		file := newTestFile(t)
+		st, err := NewStorageEncrypted(file, [][]byte{oldKey})
+		require.NoError(t, err)
+		require.NoError(t, st.Write(data))
+		// Act
+		rotated, err := NewStorageEncrypted(file, [][]byte{newKey, oldKey})
+		require.NoError(t, err)
+		read, err := rotated.Read()
+		require.NoError(t, err)
+		require.NoError(t, rotated.Write(read))
+		// Assert
+		onlyNew, err := NewStorageEncrypted(file, [][]byte{newKey})
+		require.NoError(t, err)
+		read, err = onlyNew.Read()
+		require.NoError(t, err)
+		require.Equal(t, data, read)
+		_, err = st.Read()
+		require.ErrorIs(t, err, ErrDecrypt)
+	})
+	t.Run("should refuse tampered data", func(t *testing.T) {
+		// Arrange
+		file := newTestFile(t)
+		st, err := NewStorageEncrypted(file, [][]byte{newTestKey(t)})
+		require.NoError(t, err)
+		require.NoError(t, st.Write(data))
+		sealed, err := file.Read()
+		require.NoError(t, err)
+		sealed[len(sealed)-1] ^= 1
+		require.NoError(t, os.WriteFile(file.FileName, sealed, 0644))
+		// Act
+		_, err = st.Read()
+		// Assert
+		require.ErrorIs(t, err, ErrDecrypt)
+	})
+	t.Run("should refuse plaintext unless allowed", func(t *testing.T) {
+		// Arrange
+		st, err := NewStorageEncrypted(NewStorageMemory(data), [][]byte{newTestKey(t)})
+		require.NoError(t, err)
+		// Act
+		_, err = st.Read()
+		require.ErrorIs(t, err, ErrPlaintext)
+		st.AllowPlaintext = true
+		read, err := st.Read()
+		// Assert
+		require.NoError(t, err)
+		require.Equal(t, data, read)
+	})
+	t.Run("should parse keys and reject the wrong size", func(t *testing.T) {
+		// Arrange
+		a, b := newTestKey(t), newTestKey(t)
+		text := base64.StdEncoding.EncodeToString(a) + "\n" + base64.StdEncoding.EncodeToString(b) + "\n"
+		// Act
+		keys, err := ParseEncryptionKeys(text)
+		// Assert
+		require.NoError(t, err)
+		require.Equal(t, [][]byte{a, b}, keys)
+		_, err = NewStorageEncrypted(NewStorageMemory(nil), [][]byte{a[:16]})
+		require.Error(t, err)
+	})
+}
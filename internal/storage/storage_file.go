@@ -0,0 +1,291 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"web/clase1/platform/tools"
+)
+
+// StorageFile keeps raw bytes in a file. Writes go to a temp file that is
+// synced and renamed over FileName, so the file is never left half written.
+// Up to Backups previous versions are kept as FileName.bak.1 (newest) to
+// FileName.bak.N, and Read falls back to them if Valid rejects FileName.
+//
+// Reads and writes hold an advisory lock on FileName.lock so processes
+// sharing the file don't interleave them. Write returns ErrConflict instead
+// of replacing content this StorageFile hasn't read or written itself.
+type StorageFile struct {
+	FileName string
+	Backups  int
+	// Valid reports whether the content of a file is intact, nil accepts any
+	Valid func([]byte) bool
+
+	// mu guards seen
+	mu sync.Mutex
+	// seen is the hash of FileName as last read or written, nil until then
+	seen []byte
+}
+
+func NewStorageFile(fileName string) *StorageFile {
+	return &StorageFile{
+		FileName: fileName,
+	}
+}
+
+func (s *StorageFile) Read() ([]byte, error) {
+	return s.read(true)
+}
+
+// ReadCurrent is Read without the fallback to backups
+func (s *StorageFile) ReadCurrent() ([]byte, error) {
+	return s.read(false)
+}
+
+func (s *StorageFile) read(fallback bool) ([]byte, error) {
+	unlock, err := s.lock(false)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	data, err := tools.ReadFile(s.FileName)
+	if err != nil {
+		return nil, err
+	}
+	s.see(data)
+	if s.valid(data) {
+		return data, nil
+	}
+
+	for i := 1; fallback && i <= s.Backups; i++ {
+		backup, err := tools.ReadFile(s.backupName(i))
+		if err == nil && s.valid(backup) {
+			return backup, nil
+		}
+	}
+	return nil, fmt.Errorf("%s: %w", s.FileName, ErrCorrupted)
+}
+
+// ReadStream opens FileName for streaming. Unlike Read it can't check the
+// content up front, callers fall back to Read if decoding the stream fails.
+// The lock is held until the stream is closed.
+func (s *StorageFile) ReadStream() (io.ReadCloser, error) {
+	unlock, err := s.lock(false)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(s.FileName)
+	if err != nil {
+		unlock()
+		return nil, err
+	}
+	return &fileStream{file: f, hash: sha256.New(), storage: s, unlock: unlock}, nil
+}
+
+func (s *StorageFile) Write(data []byte) error {
+	unlock, err := s.lock(true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return s.writeLocked(data)
+}
+
+// writeLocked is Write for callers already holding the exclusive lock
+func (s *StorageFile) writeLocked(data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.checkSeen(); err != nil {
+		return err
+	}
+	if err := s.write(data); err != nil {
+		return err
+	}
+	sum := sha256.Sum256(data)
+	s.seen = sum[:]
+	return nil
+}
+
+func (s *StorageFile) write(data []byte) error {
+	// Write data to a temp file next to the target
+	dir := filepath.Dir(s.FileName)
+	tmp, err := os.CreateTemp(dir, filepath.Base(s.FileName)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+
+	if err := s.rotateBackups(); err != nil {
+		return err
+	}
+
+	// Replace the target
+	if err := os.Rename(tmp.Name(), s.FileName); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+// lock takes the advisory lock of FileName, the returned func releases it.
+// The lock lives in its own file since writes replace FileName.
+func (s *StorageFile) lock(exclusive bool) (func(), error) {
+	f, err := os.OpenFile(s.FileName+".lock", os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFile(f, exclusive); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return func() {
+		unlockFile(f)
+		f.Close()
+	}, nil
+}
+
+// see records data as the last known content of FileName
+func (s *StorageFile) see(data []byte) {
+	sum := sha256.Sum256(data)
+	s.mu.Lock()
+	s.seen = sum[:]
+	s.mu.Unlock()
+}
+
+// checkSeen reports ErrConflict when FileName no longer holds the content
+// last read or written, expects mu and the exclusive lock to be held
+func (s *StorageFile) checkSeen() error {
+	if s.seen == nil {
+		return nil
+	}
+	current, err := os.ReadFile(s.FileName)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	// a removed file hashes as empty, and conflicts unless it was
+	sum := sha256.Sum256(current)
+	if !bytes.Equal(sum[:], s.seen) {
+		return fmt.Errorf("%s: %w", s.FileName, ErrConflict)
+	}
+	return nil
+}
+
+// fileStream hashes the file as it is read, recording it as seen once it
+// has been read to the end
+type fileStream struct {
+	file    *os.File
+	hash    hash.Hash
+	storage *StorageFile
+	unlock  func()
+	eof     bool
+}
+
+func (f *fileStream) Read(p []byte) (int, error) {
+	n, err := f.file.Read(p)
+	f.hash.Write(p[:n])
+	if err == io.EOF {
+		f.eof = true
+	}
+	return n, err
+}
+
+func (f *fileStream) Close() error {
+	defer f.unlock()
+	if f.eof {
+		f.storage.mu.Lock()
+		f.storage.seen = f.hash.Sum(nil)
+		f.storage.mu.Unlock()
+	}
+	return f.file.Close()
+}
+
+func (s *StorageFile) valid(data []byte) bool {
+	return s.Valid == nil || s.Valid(data)
+}
+
+func (s *StorageFile) backupName(generation int) string {
+	return fmt.Sprintf("%s.bak.%d", s.FileName, generation)
+}
+
+// rotateBackups shifts every backup one generation back and keeps the
+// current file as the newest one
+func (s *StorageFile) rotateBackups() error {
+	if s.Backups <= 0 {
+		return nil
+	}
+	if _, err := os.Stat(s.FileName); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	for i := s.Backups - 1; i >= 1; i-- {
+		err := os.Rename(s.backupName(i), s.backupName(i+1))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+
+	newest := s.backupName(1)
+	if err := os.Remove(newest); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	// a hard link keeps the current content once the target is replaced
+	if err := os.Link(s.FileName, newest); err == nil {
+		return nil
+	}
+	return copyFile(s.FileName, newest)
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// syncDir makes a rename in dir durable
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
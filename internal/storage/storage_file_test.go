@@ -0,0 +1,63 @@
+package storage
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStorageFileConflict(t *testing.T) {
+	t.Run("should refuse to overwrite changes made by another process", func(t *testing.T) {
+		// Arrange
+		path := filepath.Join(t.TempDir(), "products.json")
+		require.NoError(t, os.WriteFile(path, []byte(`[1]`), 0644))
+		first, second := NewStorageFile(path), NewStorageFile(path)
+		_, err := first.Read()
+		require.NoError(t, err)
+		_, err = second.Read()
+		require.NoError(t, err)
+		require.NoError(t, first.Write([]byte(`[1,2]`)))
+		// Act
+		err = second.Write([]byte(`[1,3]`))
+		// Assert
+		require.ErrorIs(t, err, ErrConflict)
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		require.Equal(t, `[1,2]`, string(data))
+	})
+	t.Run("should write once the other changes have been read", func(t *testing.T) {
+		// Arrange
+		path := filepath.Join(t.TempDir(), "products.json")
+		require.NoError(t, os.WriteFile(path, []byte(`[1]`), 0644))
+		st := NewStorageFile(path)
+		_, err := st.Read()
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(path, []byte(`[1,2]`), 0644))
+		require.ErrorIs(t, st.Write([]byte(`[1,3]`)), ErrConflict)
+		// Act
+		_, err = st.Read()
+		require.NoError(t, err)
+		err = st.Write([]byte(`[1,2,3]`))
+		// Assert
+		require.NoError(t, err)
+	})
+	t.Run("should detect changes seen through a stream", func(t *testing.T) {
+		// Arrange
+		path := filepath.Join(t.TempDir(), "products.json")
+		require.NoError(t, os.WriteFile(path, []byte(`[1]`), 0644))
+		st := NewStorageFile(path)
+		stream, err := st.ReadStream()
+		require.NoError(t, err)
+		_, err = io.ReadAll(stream)
+		require.NoError(t, err)
+		require.NoError(t, stream.Close())
+		require.NoError(t, os.WriteFile(path, []byte(`[2]`), 0644))
+		// Act
+		err = st.Write([]byte(`[3]`))
+		// Assert
+		require.ErrorIs(t, err, ErrConflict)
+	})
+}
@@ -2,43 +2,52 @@ package storage
 
 import (
 	"encoding/json"
-	"io"
-	"os"
-	"web/clase1/platform/tools"
+	"errors"
+	"fmt"
 )
 
+var (
+	ErrInvalidJSON = errors.New("invalid json")
+	ErrCorrupted   = errors.New("file and backups are corrupted")
+	ErrConflict    = errors.New("file was changed by another process")
+)
+
+// StorageJSON is a StorageFile that only accepts JSON, and falls back to
+// its backups when FileName doesn't parse. Both checks go through Valid,
+// which decorators encoding the JSON wrap to decode it first.
 type StorageJSON struct {
-	FileName string
+	StorageFile
 }
 
 func NewStorageJSON(fileName string) *StorageJSON {
 	return &StorageJSON{
-		FileName: fileName,
+		StorageFile: StorageFile{
+			FileName: fileName,
+			Valid:    json.Valid,
+		},
 	}
 }
 
 func (s *StorageJSON) Read() ([]byte, error) {
-	return tools.ReadFile(s.FileName)
+	return s.checkJSON(s.StorageFile.Read())
 }
 
-func (s *StorageJSON) Write(data []byte) error {
-	// Check if data has JSON format
-	var jsonData interface{}
-	err := json.Unmarshal(data, &jsonData)
-	if err != nil {
-		return err
-	}
+func (s *StorageJSON) ReadCurrent() ([]byte, error) {
+	return s.checkJSON(s.StorageFile.ReadCurrent())
+}
 
-	// Write data to file
-	file, err := os.OpenFile(s.FileName, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
-	if err != nil {
-		return err
-	}
-	w := io.Writer(file)
-	_, err = w.Write(data)
-	if err != nil {
-		return err
+// checkJSON reports the content StorageFile rejected as invalid JSON
+func (s *StorageJSON) checkJSON(data []byte, err error) ([]byte, error) {
+	if errors.Is(err, ErrCorrupted) {
+		return nil, fmt.Errorf("%s: %w", s.FileName, ErrInvalidJSON)
 	}
+	return data, err
+}
 
-	return nil
+func (s *StorageJSON) Write(data []byte) error {
+	// Check if data has JSON format
+	if !s.valid(data) {
+		return ErrInvalidJSON
+	}
+	return s.StorageFile.Write(data)
 }
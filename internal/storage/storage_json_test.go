@@ -0,0 +1,99 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStorageJSON(t *testing.T) {
+	t.Run("should replace the file without leaving temp files", func(t *testing.T) {
+		// Arrange
+		dir := t.TempDir()
+		st := NewStorageJSON(filepath.Join(dir, "products.json"))
+		// Act
+		require.NoError(t, st.Write([]byte(`[1]`)))
+		require.NoError(t, st.Write([]byte(`[1,2]`)))
+		// Assert
+		data, err := st.Read()
+		require.NoError(t, err)
+		require.Equal(t, `[1,2]`, string(data))
+
+		// only the file and its lock are left
+		entries, err := os.ReadDir(dir)
+		require.NoError(t, err)
+		require.Len(t, entries, 2)
+	})
+	t.Run("should reject data that is not json", func(t *testing.T) {
+		// Arrange
+		path := filepath.Join(t.TempDir(), "products.json")
+		require.NoError(t, os.WriteFile(path, []byte(`[1]`), 0644))
+		st := NewStorageJSON(path)
+		// Act
+		err := st.Write([]byte(`[1,`))
+		// Assert
+		require.ErrorIs(t, err, ErrInvalidJSON)
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		require.Equal(t, `[1]`, string(data))
+	})
+	t.Run("should keep the configured backup generations", func(t *testing.T) {
+		// Arrange
+		path := filepath.Join(t.TempDir(), "products.json")
+		st := NewStorageJSON(path)
+		st.Backups = 2
+		// Act
+		for _, data := range []string{`[1]`, `[2]`, `[3]`, `[4]`} {
+			require.NoError(t, st.Write([]byte(data)))
+		}
+		// Assert
+		for file, expected := range map[string]string{path: `[4]`, path + ".bak.1": `[3]`, path + ".bak.2": `[2]`} {
+			data, err := os.ReadFile(file)
+			require.NoError(t, err)
+			require.Equal(t, expected, string(data))
+		}
+		require.NoFileExists(t, path+".bak.3")
+	})
+	t.Run("should read the newest valid backup when the file is corrupted", func(t *testing.T) {
+		// Arrange
+		path := filepath.Join(t.TempDir(), "products.json")
+		st := NewStorageJSON(path)
+		st.Backups = 2
+		require.NoError(t, st.Write([]byte(`[1]`)))
+		require.NoError(t, st.Write([]byte(`[2]`)))
+		require.NoError(t, st.Write([]byte(`[3]`)))
+		require.NoError(t, os.WriteFile(path, []byte(`[3,`), 0644))
+		require.NoError(t, os.WriteFile(path+".bak.1", nil, 0644))
+		// Act
+		data, err := st.Read()
+		// Assert
+		require.NoError(t, err)
+		require.Equal(t, `[1]`, string(data))
+	})
+	t.Run("should not read a backup for the current content", func(t *testing.T) {
+		// Arrange
+		path := filepath.Join(t.TempDir(), "products.json")
+		st := NewStorageJSON(path)
+		st.Backups = 2
+		require.NoError(t, st.Write([]byte(`[1]`)))
+		require.NoError(t, st.Write([]byte(`[2]`)))
+		require.NoError(t, os.WriteFile(path, []byte(`[2,`), 0644))
+		// Act
+		_, err := ReadCurrent(st)
+		// Assert
+		require.ErrorIs(t, err, ErrInvalidJSON)
+	})
+	t.Run("should fail when neither the file nor a backup parse", func(t *testing.T) {
+		// Arrange
+		path := filepath.Join(t.TempDir(), "products.json")
+		require.NoError(t, os.WriteFile(path, []byte(`{`), 0644))
+		st := NewStorageJSON(path)
+		st.Backups = 2
+		// Act
+		_, err := st.Read()
+		// Assert
+		require.ErrorIs(t, err, ErrInvalidJSON)
+	})
+}
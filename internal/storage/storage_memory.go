@@ -0,0 +1,46 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"sync"
+)
+
+// StorageMemory keeps data in memory. It's meant for tests, so each one
+// gets its own isolated catalog.
+type StorageMemory struct {
+	mu   sync.Mutex
+	data []byte
+}
+
+func NewStorageMemory(data []byte) *StorageMemory {
+	return &StorageMemory{
+		data: append([]byte(nil), data...),
+	}
+}
+
+func (s *StorageMemory) Read() ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]byte(nil), s.data...), nil
+}
+
+func (s *StorageMemory) ReadStream() (io.ReadCloser, error) {
+	data, _ := s.Read()
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (s *StorageMemory) Write(data []byte) error {
+	// Check if data has JSON format, like StorageJSON does
+	if !json.Valid(data) {
+		return ErrInvalidJSON
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.data = append([]byte(nil), data...)
+	return nil
+}
@@ -0,0 +1,196 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// StorageWAL is a Journal keeping a JSON snapshot plus an append-only log
+// with one JSON entry per line. Entries must be idempotent: a crash between
+// writing a snapshot and truncating the log replays entries the snapshot
+// already contains.
+//
+// Writes and appends hold the exclusive lock of the snapshot, and fail with
+// ErrConflict when another process changed the snapshot or the log since
+// they were last read or written.
+type StorageWAL struct {
+	Snapshot *StorageJSON
+	LogName  string
+	// CompactAfter is the number of entries after which Append asks for a
+	// new snapshot, 0 never asks
+	CompactAfter int
+
+	mu      sync.Mutex
+	pending int
+	// logSize is the size of the log last replayed or written. The log is
+	// only appended to between snapshots, which change the snapshot too, so
+	// a different size tells another process changed it.
+	logSize int64
+}
+
+func NewStorageWAL(snapshotName, logName string, compactAfter int) *StorageWAL {
+	return &StorageWAL{
+		Snapshot:     NewStorageJSON(snapshotName),
+		LogName:      logName,
+		CompactAfter: compactAfter,
+	}
+}
+
+func (s *StorageWAL) Read() ([]byte, error) {
+	return s.Snapshot.Read()
+}
+
+func (s *StorageWAL) ReadCurrent() ([]byte, error) {
+	return s.Snapshot.ReadCurrent()
+}
+
+func (s *StorageWAL) ReadStream() (io.ReadCloser, error) {
+	return s.Snapshot.ReadStream()
+}
+
+// Write stores data as the new snapshot and empties the log
+func (s *StorageWAL) Write(data []byte) error {
+	if !s.Snapshot.valid(data) {
+		return ErrInvalidJSON
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	unlock, err := s.Snapshot.lock(true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// entries appended by another process would be lost with the log
+	if err := s.checkLog(); err != nil {
+		return err
+	}
+	if err := s.Snapshot.writeLocked(data); err != nil {
+		return err
+	}
+	if err := os.Truncate(s.LogName, 0); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	s.pending = 0
+	s.logSize = 0
+	return nil
+}
+
+// checkLog reports ErrConflict when the log isn't the size last seen,
+// expects mu and the exclusive lock to be held
+func (s *StorageWAL) checkLog() error {
+	var size int64
+	info, err := os.Stat(s.LogName)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err == nil {
+		size = info.Size()
+	}
+	if size != s.logSize {
+		return fmt.Errorf("%s: %w", s.LogName, ErrConflict)
+	}
+	return nil
+}
+
+func (s *StorageWAL) Append(entry []byte) (bool, error) {
+	if bytes.ContainsRune(entry, '\n') {
+		return false, errors.New("wal entries can't span several lines")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	unlock, err := s.Snapshot.lock(true)
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+
+	// the entry changes the products last seen, which must be current
+	s.Snapshot.mu.Lock()
+	err = s.Snapshot.checkSeen()
+	s.Snapshot.mu.Unlock()
+	if err != nil {
+		return false, err
+	}
+	if err := s.checkLog(); err != nil {
+		return false, err
+	}
+
+	file, err := os.OpenFile(s.LogName, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return false, err
+	}
+	line := append(entry, '\n')
+	if _, err := file.Write(line); err != nil {
+		file.Close()
+		return false, err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return false, err
+	}
+	if err := file.Close(); err != nil {
+		return false, err
+	}
+
+	s.logSize += int64(len(line))
+	s.pending++
+	return s.CompactAfter > 0 && s.pending >= s.CompactAfter, nil
+}
+
+// Replay calls apply with every complete entry of the log, in order. A last
+// line without its newline was cut short by a crash, it's dropped from the
+// log so later entries don't get appended to it.
+func (s *StorageWAL) Replay(apply func(entry []byte) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// dropping a cut short line writes to the log
+	unlock, err := s.Snapshot.lock(true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	s.pending = 0
+	s.logSize = 0
+	file, err := os.Open(s.LogName)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var offset int64
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			s.logSize = offset
+			if len(line) > 0 {
+				return os.Truncate(s.LogName, offset)
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := apply(bytes.TrimSuffix(line, []byte("\n"))); err != nil {
+			return err
+		}
+		offset += int64(len(line))
+		s.pending++
+	}
+}
@@ -0,0 +1,108 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newTestWAL(t *testing.T, compactAfter int) *StorageWAL {
+	t.Helper()
+
+	dir := t.TempDir()
+	st := NewStorageWAL(filepath.Join(dir, "products.json"), filepath.Join(dir, "products.wal"), compactAfter)
+	require.NoError(t, os.WriteFile(st.Snapshot.FileName, []byte(`[]`), 0644))
+	return st
+}
+
+func replayed(t *testing.T, st *StorageWAL) []string {
+	t.Helper()
+
+	var entries []string
+	require.NoError(t, st.Replay(func(entry []byte) error {
+		entries = append(entries, string(entry))
+		return nil
+	}))
+	return entries
+}
+
+func TestStorageWAL(t *testing.T) {
+	t.Run("should replay appended entries in order", func(t *testing.T) {
+		// Arrange
+		st := newTestWAL(t, 0)
+		// Act
+		for _, entry := range []string{`{"op":"create"}`, `{"op":"delete"}`} {
+			compact, err := st.Append([]byte(entry))
+			require.NoError(t, err)
+			require.False(t, compact)
+		}
+		// Assert
+		require.Equal(t, []string{`{"op":"create"}`, `{"op":"delete"}`}, replayed(t, st))
+	})
+	t.Run("should ask for a snapshot after the configured entries", func(t *testing.T) {
+		// Arrange
+		st := newTestWAL(t, 2)
+		// Act
+		compact, err := st.Append([]byte(`{"n":1}`))
+		require.NoError(t, err)
+		require.False(t, compact)
+		compact, err = st.Append([]byte(`{"n":2}`))
+		require.NoError(t, err)
+		require.True(t, compact)
+		require.NoError(t, st.Write([]byte(`[1,2]`)))
+		// Assert
+		require.Empty(t, replayed(t, st))
+		data, err := st.Read()
+		require.NoError(t, err)
+		require.Equal(t, `[1,2]`, string(data))
+	})
+	t.Run("should drop an entry cut short by a crash", func(t *testing.T) {
+		// Arrange
+		st := newTestWAL(t, 0)
+		_, err := st.Append([]byte(`{"n":1}`))
+		require.NoError(t, err)
+		file, err := os.OpenFile(st.LogName, os.O_WRONLY|os.O_APPEND, 0644)
+		require.NoError(t, err)
+		_, err = file.WriteString(`{"n":`)
+		require.NoError(t, err)
+		require.NoError(t, file.Close())
+		// Act
+		entries := replayed(t, st)
+		_, err = st.Append([]byte(`{"n":2}`))
+		require.NoError(t, err)
+		// Assert
+		require.Equal(t, []string{`{"n":1}`}, entries)
+		require.Equal(t, []string{`{"n":1}`, `{"n":2}`}, replayed(t, st))
+	})
+	t.Run("should reject appending after another process changed the files", func(t *testing.T) {
+		// Arrange
+		first := newTestWAL(t, 0)
+		second := NewStorageWAL(first.Snapshot.FileName, first.LogName, 0)
+		for _, st := range []*StorageWAL{first, second} {
+			_, err := st.Read()
+			require.NoError(t, err)
+			require.Empty(t, replayed(t, st))
+		}
+		_, err := first.Append([]byte(`{"n":1}`))
+		require.NoError(t, err)
+		// Act
+		_, appendErr := second.Append([]byte(`{"n":2}`))
+		writeErr := second.Write([]byte(`[]`))
+		require.NoError(t, first.Write([]byte(`[{"id":1}]`)))
+		_, err = first.Append([]byte(`{"n":3}`))
+		require.NoError(t, err)
+		require.Equal(t, []string{`{"n":3}`}, replayed(t, second))
+		_, staleErr := second.Append([]byte(`{"n":4}`))
+		// Assert
+		require.ErrorIs(t, appendErr, ErrConflict)
+		require.ErrorIs(t, writeErr, ErrConflict)
+		require.ErrorIs(t, staleErr, ErrConflict)
+		_, err = second.Read()
+		require.NoError(t, err)
+		_, err = second.Append([]byte(`{"n":4}`))
+		require.NoError(t, err)
+		require.Equal(t, []string{`{"n":3}`, `{"n":4}`}, replayed(t, first))
+	})
+}
@@ -4,4 +4,15 @@ type StandarResponse struct {
 	StatusCode int         `json:"status_code"`
 	Message    string      `json:"message"`
 	Data       interface{} `json:"data"`
+	// Pagination is only set on paginated listings
+	Pagination *Pagination `json:"pagination,omitempty"`
+}
+
+// Pagination tells where a page sits in its listing. Next and Prev are
+// links to the neighbouring pages, empty at either end.
+type Pagination struct {
+	Total int    `json:"total"`
+	Limit int    `json:"limit"`
+	Next  string `json:"next,omitempty"`
+	Prev  string `json:"prev,omitempty"`
 }
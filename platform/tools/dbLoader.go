@@ -4,24 +4,8 @@ import (
 	"os"
 )
 
+// ReadFile reads the whole file. A single Read may return less than the
+// file size, so it relies on os.ReadFile reading until EOF.
 func ReadFile(path string) ([]byte, error) {
-	file, err := os.Open(path)
-	if err != nil {
-		return nil, err
-	}
-	defer file.Close()
-
-	fileInfo, err := file.Stat()
-	if err != nil {
-		return nil, err
-	}
-
-	fileSize := fileInfo.Size()
-	bytes := make([]byte, fileSize)
-
-	_, err = file.Read(bytes)
-	if err != nil {
-		return nil, err
-	}
-	return bytes, nil
+	return os.ReadFile(path)
 }